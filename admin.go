@@ -0,0 +1,95 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// UserOverview is a user, as listed in the admin area.
+type UserOverview struct {
+	ID        int    `db:"user_id"`
+	Name      string `db:"name"`
+	Treasurer bool   `db:"treasurer"`
+	Balance   int64  `db:"balance"`
+	// LastActivity is the time of the newest transaction of the user. It is
+	// nil, if the user has none.
+	LastActivity *time.Time `db:"last_activity"`
+	Cards        []Card     `db:"-"`
+}
+
+// GetUserOverviews gets all users with their balances, cards and last
+// activity, ordered by name. It returns ErrNotTreasurer if treasurer may not
+// see them.
+func (k *Kasse) GetUserOverviews(treasurer User) ([]UserOverview, error) {
+	if ok, err := k.IsTreasurer(treasurer); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrNotTreasurer
+	}
+
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The newest transaction is the one with the highest id, as ids are
+	// sequential. Selecting its time instead of MAX(time) keeps the type of
+	// the column, which SQLite needs to return a time.Time.
+	var users []UserOverview
+	if err := tx.Select(&users, `SELECT u.user_id, u.name, u.treasurer, u.balance, t.time AS last_activity
+		FROM users u LEFT JOIN transactions t ON t.transaction_id = (SELECT MAX(transaction_id) FROM transactions WHERE user_id = u.user_id)
+		ORDER BY u.name`); err != nil {
+		return nil, err
+	}
+
+	var cards []Card
+	if err := tx.Select(&cards, `SELECT card_id, user_id, description, blocked FROM cards ORDER BY card_id`); err != nil {
+		return nil, err
+	}
+	idx := make(map[int]int)
+	for i, u := range users {
+		idx[u.ID] = i
+	}
+	for _, c := range cards {
+		if i, ok := idx[c.User]; ok {
+			users[i].Cards = append(users[i].Cards, c)
+		}
+	}
+	return users, nil
+}
+
+// PromoteTreasurer makes the user with the given name a treasurer. It is used
+// to bootstrap the first treasurer from the command line and returns
+// ErrUserNotFound if there is no such user. If the user already is a
+// treasurer, nothing is changed or audited.
+func (k *Kasse) PromoteTreasurer(name string) error {
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var user struct {
+		ID        int  `db:"user_id"`
+		Treasurer bool `db:"treasurer"`
+	}
+	if err := tx.Get(&user, `SELECT user_id, treasurer FROM users WHERE name = $1`, name); err == sql.ErrNoRows {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+	if user.Treasurer {
+		return nil
+	}
+
+	k.log.Printf("Making %s a treasurer", name)
+	if _, err := tx.Exec(`UPDATE users SET treasurer = $1 WHERE user_id = $2`, true, user.ID); err != nil {
+		return err
+	}
+	if err := k.audit(tx, User{}, "user.treasurer", fmt.Sprintf("user %d", user.ID), user.Treasurer, true); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestGetUserOverviews(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	defer k.db.Close()
+
+	last := time.Date(2015, 4, 6, 23, 5, 27, 0, time.UTC)
+	insertData(t, k.db, []User{
+		{ID: 1, Name: "Merovius", Password: []byte("password")},
+		{ID: 2, Name: "Koebi", Password: []byte("password1")},
+	}, []Card{
+		{ID: []byte("aaaa"), User: 1},
+		{ID: []byte("aaab"), User: 1, Blocked: true},
+	}, []Transaction{
+		{ID: 1, User: 1, Time: last.Add(-time.Hour), Amount: 1000, Kind: "Aufladung"},
+		{ID: 2, User: 1, Card: []byte("aaaa"), Time: last, Amount: -100, Kind: "Kartenswipe"},
+	})
+
+	if _, err := k.GetUserOverviews(User{ID: 1}); err != ErrNotTreasurer {
+		t.Errorf("GetUserOverviews(non-treasurer) = %v, want %v", err, ErrNotTreasurer)
+	}
+
+	if err := k.PromoteTreasurer("Nobody"); err != ErrUserNotFound {
+		t.Errorf("PromoteTreasurer(Nobody) = %v, want %v", err, ErrUserNotFound)
+	}
+	// -make-treasurer is applied on every start, but only audited once.
+	for i := 0; i < 2; i++ {
+		if err := k.PromoteTreasurer("Merovius"); err != nil {
+			t.Fatalf("PromoteTreasurer(Merovius) = %v", err)
+		}
+	}
+	var promotions int
+	if err := k.db.Get(&promotions, `SELECT COUNT(*) FROM audit_log WHERE action = $1`, "user.treasurer"); err != nil || promotions != 1 {
+		t.Errorf("PromoteTreasurer(Merovius) twice made %d audit entries (%v), want 1", promotions, err)
+	}
+
+	users, err := k.GetUserOverviews(User{ID: 1})
+	if err != nil {
+		t.Fatalf("GetUserOverviews() = %v", err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("GetUserOverviews() returned %d users, want 2", len(users))
+	}
+
+	koebi, mero := users[0], users[1]
+	if koebi.Name != "Koebi" || koebi.Treasurer || koebi.Balance != 0 || koebi.LastActivity != nil || len(koebi.Cards) != 0 {
+		t.Errorf("GetUserOverviews()[0] = %+v, want Koebi without cards and activity", koebi)
+	}
+	if mero.Name != "Merovius" || !mero.Treasurer || mero.Balance != 900 {
+		t.Errorf("GetUserOverviews()[1] = %+v, want treasurer Merovius with balance 900", mero)
+	}
+	if mero.LastActivity == nil || !mero.LastActivity.Equal(last) {
+		t.Errorf("last activity of Merovius is %v, want %v", mero.LastActivity, last)
+	}
+	if len(mero.Cards) != 2 || !bytes.Equal(mero.Cards[0].ID, []byte("aaaa")) || !mero.Cards[1].Blocked {
+		t.Errorf("cards of Merovius are %+v, want aaaa and blocked aaab", mero.Cards)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// APIReaderName is the name recorded in transactions for swipes made through
+// the API.
+const APIReaderName = "api"
+
+// errNotLoggedIn is returned by the API, if a request needs a logged in user
+// but the session has none.
+var errNotLoggedIn = errors.New("not logged in")
+
+// errBadRequest is returned by the API, if the request could not be parsed.
+var errBadRequest = errors.New("bad request")
+
+// APIUser is a User, as returned by the API.
+type APIUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// APICard is a Card, as returned by the API.
+type APICard struct {
+	UID         string `json:"uid"`
+	Description string `json:"description"`
+	Blocked     bool   `json:"blocked"`
+}
+
+// APITransaction is a Transaction, as returned by the API. Amounts are in
+// cents.
+type APITransaction struct {
+	ID           int    `json:"id"`
+	Card         string `json:"card,omitempty"`
+	Time         string `json:"time"`
+	Amount       int    `json:"amount"`
+	Kind         string `json:"kind"`
+	Product      string `json:"product,omitempty"`
+	Counterparty string `json:"counterparty,omitempty"`
+	Balance      int64  `json:"balance"`
+}
+
+// APIResult is the Result of a swipe, as returned by the API. Balance is the
+// balance (in cents) after the swipe.
+type APIResult struct {
+	Code    string `json:"code"`
+	UID     string `json:"uid"`
+	User    string `json:"user"`
+	Product string `json:"product"`
+	Balance int64  `json:"balance"`
+}
+
+// apiStatus returns the HTTP status code, that corresponds to err.
+func apiStatus(err error) int {
+	switch err {
+	case ErrWrongAuth, errNotLoggedIn:
+		return http.StatusUnauthorized
+	case ErrUserExists, ErrCardExists:
+		return http.StatusConflict
+	case ErrCardNotFound, ErrProductNotFound, ErrUserNotFound:
+		return http.StatusNotFound
+	case ErrAccountEmpty:
+		return http.StatusPaymentRequired
+	case ErrCardBlocked:
+		return http.StatusForbidden
+	case ErrTooManyAttempts:
+		return http.StatusTooManyRequests
+	case ErrInvalidAmount, ErrTransferToSelf, errBadRequest:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeJSON writes v as the JSON body of a response with the given status.
+func (k *Kasse) writeJSON(res http.ResponseWriter, status int, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	if err := json.NewEncoder(res).Encode(v); err != nil {
+		k.log.Println("Could not write JSON response:", err)
+	}
+}
+
+// writeAPIError writes err as a JSON body with the corresponding status.
+// Internal errors are logged and not passed on to the client.
+func (k *Kasse) writeAPIError(res http.ResponseWriter, err error) {
+	status := apiStatus(err)
+	if status == http.StatusInternalServerError {
+		k.log.Println("Internal error in API:", err)
+		err = errors.New("internal error")
+	}
+	k.writeJSON(res, status, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+// apiHandler wraps API handlers that need a logged in user.
+func (k *Kasse) apiHandler(f func(res http.ResponseWriter, req *http.Request, user User)) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		user, ok := k.sessionUser(req)
+		if !ok {
+			k.writeAPIError(res, errNotLoggedIn)
+			return
+		}
+		f(res, req, user)
+	}
+}
+
+// registerAPI registers the handlers of the JSON API on r.
+func (k *Kasse) registerAPI(r *mux.Router) {
+	r.Methods("POST").Path("/login").HandlerFunc(k.APILogin)
+	r.Methods("POST").Path("/logout").HandlerFunc(k.APILogout)
+	r.Methods("POST").Path("/users").HandlerFunc(k.APINewUser)
+	r.Methods("GET").Path("/balance").Handler(k.apiHandler(k.APIBalance))
+	r.Methods("GET").Path("/cards").Handler(k.apiHandler(k.APICards))
+	r.Methods("GET").Path("/transactions").Handler(k.apiHandler(k.APITransactions))
+	r.Methods("POST").Path("/swipe").Handler(k.apiHandler(k.APISwipe))
+	r.Methods("POST").Path("/transfers").Handler(k.apiHandler(k.APITransfer))
+}
+
+// apiCredentials is the body of login and registration requests.
+type apiCredentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// readCredentials reads apiCredentials from the body of req.
+func (k *Kasse) readCredentials(req *http.Request) (apiCredentials, error) {
+	var c apiCredentials
+	if err := json.NewDecoder(req.Body).Decode(&c); err != nil || c.Username == "" || c.Password == "" {
+		return c, errBadRequest
+	}
+	return c, nil
+}
+
+// saveSessionUser saves user as the logged in user in the session.
+func (k *Kasse) saveSessionUser(res http.ResponseWriter, req *http.Request, user *User) {
+	session, _ := k.sessions.Get(req, "nnev-kasse")
+	session.Values["user"] = user
+	if err := session.Save(req, res); err != nil {
+		k.log.Printf("Error saving session: %v", err)
+	}
+}
+
+// APILogin authenticates the user given by a JSON body with username and
+// password and saves them in the session.
+func (k *Kasse) APILogin(res http.ResponseWriter, req *http.Request) {
+	c, err := k.readCredentials(req)
+	if err != nil {
+		k.writeAPIError(res, err)
+		return
+	}
+
+	user, err := k.Login(c.Username, []byte(c.Password), k.remoteIP(req))
+	if err != nil {
+		k.writeAPIError(res, err)
+		return
+	}
+
+	k.saveSessionUser(res, req, user)
+	k.writeJSON(res, http.StatusOK, APIUser{user.ID, user.Name})
+}
+
+// APILogout removes the logged in user from the session.
+func (k *Kasse) APILogout(res http.ResponseWriter, req *http.Request) {
+	if session, err := k.sessions.Get(req, "nnev-kasse"); err == nil {
+		delete(session.Values, "user")
+		if err := session.Save(req, res); err != nil {
+			k.log.Printf("Error saving session: %v", err)
+		}
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+// APINewUser registers a new user given by a JSON body with username and
+// password and saves them in the session.
+func (k *Kasse) APINewUser(res http.ResponseWriter, req *http.Request) {
+	c, err := k.readCredentials(req)
+	if err != nil {
+		k.writeAPIError(res, err)
+		return
+	}
+
+	user, err := k.RegisterUser(c.Username, []byte(c.Password))
+	if err != nil {
+		k.writeAPIError(res, err)
+		return
+	}
+
+	k.saveSessionUser(res, req, user)
+	k.writeJSON(res, http.StatusCreated, APIUser{user.ID, user.Name})
+}
+
+// APIBalance returns the balance of the logged in user in cents.
+func (k *Kasse) APIBalance(res http.ResponseWriter, req *http.Request, user User) {
+	balance, err := k.GetBalance(user)
+	if err != nil {
+		k.writeAPIError(res, err)
+		return
+	}
+
+	k.writeJSON(res, http.StatusOK, struct {
+		Balance int64 `json:"balance"`
+	}{balance})
+}
+
+// APICards returns the cards of the logged in user.
+func (k *Kasse) APICards(res http.ResponseWriter, req *http.Request, user User) {
+	cards, err := k.GetCards(user)
+	if err != nil {
+		k.writeAPIError(res, err)
+		return
+	}
+
+	out := []APICard{}
+	for _, c := range cards {
+		out = append(out, APICard{fmt.Sprintf("%x", c.ID), c.Description, c.Blocked})
+	}
+	k.writeJSON(res, http.StatusOK, out)
+}
+
+// APITransactions returns the transactions of the logged in user, newest
+// first. It accepts the same filters as the transaction history and a limit,
+// which defaults to transactionsPageSize.
+func (k *Kasse) APITransactions(res http.ResponseWriter, req *http.Request, user User) {
+	q, err := parseTransactionQuery(req, user)
+	if err != nil {
+		k.writeAPIError(res, errBadRequest)
+		return
+	}
+	q.Limit = transactionsPageSize
+	if s := req.FormValue("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil {
+			k.writeAPIError(res, errBadRequest)
+			return
+		}
+	}
+
+	transactions, err := k.QueryTransactions(q)
+	if err != nil {
+		k.writeAPIError(res, err)
+		return
+	}
+
+	out := []APITransaction{}
+	for _, t := range transactions {
+		at := APITransaction{
+			ID:           t.ID,
+			Time:         t.Time.Format(time.RFC3339),
+			Amount:       t.Amount,
+			Kind:         t.Kind,
+			Product:      t.ProductName.String,
+			Counterparty: t.Counterparty.String,
+			Balance:      t.Balance,
+		}
+		if t.Card != nil {
+			at.Card = fmt.Sprintf("%x", t.Card)
+		}
+		out = append(out, at)
+	}
+	k.writeJSON(res, http.StatusOK, out)
+}
+
+// APISwipe charges one of the cards of the logged in user, as if it was
+// swiped at the reader. The JSON body contains the hex-encoded uid and
+// optionally the id of a product. It returns the result of the swipe.
+func (k *Kasse) APISwipe(res http.ResponseWriter, req *http.Request, user User) {
+	var body struct {
+		UID     string `json:"uid"`
+		Product int    `json:"product"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		k.writeAPIError(res, errBadRequest)
+		return
+	}
+	uid, err := parseUID(body.UID)
+	if err != nil {
+		k.writeAPIError(res, errBadRequest)
+		return
+	}
+
+	// Only allow swiping own cards, so nobody can charge other accounts.
+	if _, err := k.GetCard(uid, user); err != nil {
+		k.writeAPIError(res, err)
+		return
+	}
+
+	var product *Product
+	if body.Product != 0 {
+		if product, err = k.GetProduct(body.Product); err != nil {
+			k.writeAPIError(res, err)
+			return
+		}
+	}
+
+	result, err := k.HandleCard(APIReaderName, uid, product)
+	if err != nil {
+		k.writeAPIError(res, err)
+		return
+	}
+
+	k.writeJSON(res, http.StatusOK, APIResult{
+		Code:    result.Code.String(),
+		UID:     fmt.Sprintf("%x", uid),
+		User:    result.User,
+		Product: result.Product,
+		Balance: result.Balance,
+	})
+}
+
+// APITransfer transfers money from the logged in user to another user. The
+// JSON body contains the name of the recipient and the amount in cents. It
+// returns the result code and the balance (in cents) after the transfer.
+func (k *Kasse) APITransfer(res http.ResponseWriter, req *http.Request, user User) {
+	var body struct {
+		To     string `json:"to"`
+		Amount int    `json:"amount"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.To == "" {
+		k.writeAPIError(res, errBadRequest)
+		return
+	}
+
+	code, err := k.Transfer(user, body.To, body.Amount)
+	if err != nil {
+		k.writeAPIError(res, err)
+		return
+	}
+
+	balance, err := k.GetBalance(user)
+	if err != nil {
+		k.writeAPIError(res, err)
+		return
+	}
+
+	k.writeJSON(res, http.StatusOK, struct {
+		Code    string `json:"code"`
+		Balance int64  `json:"balance"`
+	}{code.String(), balance})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestAPI(t *testing.T) {
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	k.sessions = sessions.NewCookieStore([]byte("foobar"))
+	h := k.Handler()
+
+	jar, _ := cookiejar.New(nil)
+
+	insertData(t, k.db, []User{
+		{
+			ID:   1,
+			Name: "Merovius",
+			// "foobar"
+			Password: []byte("$2a$10$HvkgrSxCQxOSFB4vvPd0SuP5urdZUuXSMumMYA5qjli9Mh0pcVDXS"),
+		},
+	}, []Card{
+		{ID: []byte("aaaa"), User: 1, Description: "Schlüssel"},
+		{ID: []byte("baaa"), User: 3},
+	}, []Transaction{
+		{ID: 1, User: 1, Time: time.Now(), Amount: 150, Kind: "Aufladung"},
+	})
+	if _, err := k.AddProduct(User{}, "Mate", 100); err != nil {
+		t.Fatalf("could not add product: %v", err)
+	}
+
+	tests := []struct {
+		// inputs
+		method string
+		url    string
+		body   string
+
+		// expected outputs
+		code int
+		grep string
+	}{
+		{"GET", "http://localhost:9000/api/v1/balance", "", http.StatusUnauthorized, `"error":"not logged in"`},
+		{"POST", "http://localhost:9000/api/v1/login", `{"username": "Merovius"}`, http.StatusBadRequest, `"error"`},
+		{"POST", "http://localhost:9000/api/v1/login", `{"username": "Merovius", "password": "foobaz"}`, http.StatusUnauthorized, `"error":"wrong username or password"`},
+		{"POST", "http://localhost:9000/api/v1/users", `{"username": "Merovius", "password": "foobaz"}`, http.StatusConflict, `"error":"username already taken"`},
+		{"POST", "http://localhost:9000/api/v1/login", `{"username": "Merovius", "password": "foobar"}`, http.StatusOK, `"name":"Merovius"`},
+		{"GET", "http://localhost:9000/api/v1/balance", "", http.StatusOK, `{"balance":150}`},
+		{"GET", "http://localhost:9000/api/v1/cards", "", http.StatusOK, `[{"uid":"61616161","description":"Schlüssel","blocked":false}]`},
+		{"POST", "http://localhost:9000/api/v1/swipe", `{"uid": "62616161"}`, http.StatusNotFound, `"error":"card not found"`},
+		{"POST", "http://localhost:9000/api/v1/swipe", `{"uid": "61616161", "product": 23}`, http.StatusNotFound, `"error":"product not found"`},
+		{"POST", "http://localhost:9000/api/v1/swipe", `{"uid": "61616161"}`, http.StatusOK, `"balance":50`},
+		{"POST", "http://localhost:9000/api/v1/swipe", `{"uid": "61616161"}`, http.StatusPaymentRequired, `"error":"account is empty"`},
+		{"GET", "http://localhost:9000/api/v1/transactions?limit=1", "", http.StatusOK, `"amount":-100`},
+		{"POST", "http://localhost:9000/api/v1/logout", "", http.StatusNoContent, ""},
+		{"GET", "http://localhost:9000/api/v1/cards", "", http.StatusUnauthorized, `"error":"not logged in"`},
+		{"POST", "http://localhost:9000/api/v1/users", `{"username": "koebi", "password": "foobaz"}`, http.StatusCreated, `"name":"koebi"`},
+		{"GET", "http://localhost:9000/api/v1/cards", "", http.StatusOK, `[]`},
+	}
+
+	for _, tc := range tests {
+		req, err := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf(`%s %s %s: %v`, tc.method, tc.url, tc.body, err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for _, c := range jar.Cookies(req.URL) {
+			req.AddCookie(c)
+		}
+
+		rec := httptest.NewRecorder()
+
+		h.ServeHTTP(rec, req)
+		if c := rec.Code; c != tc.code {
+			t.Fatalf("%s %s %s has code %d, expected %d\nFull Body:\n%s", tc.method, tc.url, tc.body, c, tc.code, rec.Body.String())
+		}
+
+		if tc.grep != "" {
+			if ct := rec.HeaderMap.Get("Content-Type"); ct != "application/json" {
+				t.Fatalf(`%s %s %s has Content-Type %q, expected "application/json"`, tc.method, tc.url, tc.body, ct)
+			}
+		}
+
+		if !strings.Contains(rec.Body.String(), tc.grep) {
+			t.Fatalf("%s %s %s: Response does not contain %q\nFull Body:\n%s", tc.method, tc.url, tc.body, tc.grep, rec.Body.String())
+		}
+
+		res := createResponse(req, rec)
+		if c := res.Cookies(); len(c) > 0 {
+			jar.SetCookies(req.URL, res.Cookies())
+		}
+	}
+}
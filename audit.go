@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// AuditEntry is an entry of the audit log. Every change made through the web
+// interface, the API or by a treasurer is recorded in it, together with the
+// values before and after the change. Swipes at a reader are not, as they are
+// fully recorded as transactions.
+//
+// The entries form a hash chain: Hash covers all other fields and PrevHash,
+// which is the Hash of the previous entry. Changing or removing an entry
+// breaks the chain, which is detected by VerifyAuditLog. Only removing the
+// newest entries can't be detected this way. The hashes are HMACs with a key
+// kept outside of the database, so that someone who can write to the database
+// can't just recompute the chain after a change.
+type AuditEntry struct {
+	ID   int       `db:"entry_id"`
+	Time time.Time `db:"time"`
+	// Actor is the user who made the change. It is not valid for changes
+	// made without a logged in user, e.g. from the command line or with a
+	// password reset link.
+	Actor     sql.NullInt64  `db:"actor_id"`
+	ActorName sql.NullString `db:"actor_name"`
+	// Action names the kind of change, e.g. "product.update".
+	Action string `db:"action"`
+	// Subject identifies the changed object, e.g. "product 3".
+	Subject string `db:"subject"`
+	// Before and After are the JSON-encoded values before and after the
+	// change. They are empty, if the object didn't exist before or after.
+	Before   string `db:"old_value"`
+	After    string `db:"new_value"`
+	PrevHash string `db:"prev_hash"`
+	Hash     string `db:"hash"`
+}
+
+// AuditLogError is returned by VerifyAuditLog, if the hash chain of the audit
+// log is broken.
+type AuditLogError struct {
+	// Entry is the id of the first entry that doesn't match the chain.
+	Entry  int
+	Reason string
+}
+
+func (e *AuditLogError) Error() string {
+	return fmt.Sprintf("audit log entry %d: %s", e.Entry, e.Reason)
+}
+
+// AuditKeyEnv is the environment variable, that the audit log key is read
+// from if no key file is given.
+const AuditKeyEnv = "KASSE_AUDIT_KEY"
+
+// LoadAuditKey reads the hex-encoded key of the audit log hashes from file
+// or, if file is empty, from the environment variable AuditKeyEnv. If neither
+// is set, it returns nil and the hashes only detect changes by someone who
+// doesn't know how they are computed.
+func LoadAuditKey(file string) ([]byte, error) {
+	v := os.Getenv(AuditKeyEnv)
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		v = string(b)
+	}
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid audit key: %v", err)
+	}
+	return key, nil
+}
+
+// hash computes the HMAC of e with key from all its fields except ID and
+// Hash.
+func (e *AuditEntry) hash(key []byte) string {
+	b, _ := json.Marshal([]interface{}{e.PrevHash, e.Time.UnixNano(), e.Actor.Int64, e.Action, e.Subject, e.Before, e.After})
+	h := hmac.New(sha256.New, key)
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// audit appends an entry to the audit log. It should be called in the same
+// database transaction as the change it records. actor is the user making the
+// change, the zero User if there is none. before and after are JSON-encoded,
+// nil values are recorded as empty.
+func (k *Kasse) audit(tx sqlx.Ext, actor User, action, subject string, before, after interface{}) error {
+	e := AuditEntry{
+		Time:    time.Now().Truncate(time.Microsecond),
+		Action:  action,
+		Subject: subject,
+	}
+	if actor.ID != 0 {
+		e.Actor = sql.NullInt64{Int64: int64(actor.ID), Valid: true}
+	}
+	for _, v := range []struct {
+		dst *string
+		val interface{}
+	}{{&e.Before, before}, {&e.After, after}} {
+		if v.val == nil {
+			continue
+		}
+		b, err := json.Marshal(v.val)
+		if err != nil {
+			return err
+		}
+		*v.dst = string(b)
+	}
+
+	// Concurrent entries would build on the same predecessor, which prev_hash
+	// being unique forbids. So they are appended one at a time: PostgreSQL
+	// has to be told to, SQLite only has one writing transaction anyway.
+	if tx.DriverName() == "postgres" {
+		if _, err := tx.Exec(`LOCK TABLE audit_log IN EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+	}
+	if err := sqlx.Get(tx, &e.PrevHash, `SELECT hash FROM audit_log ORDER BY entry_id DESC LIMIT 1`); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	e.Hash = e.hash(k.auditKey)
+
+	_, err := tx.Exec(`INSERT INTO audit_log (time, actor_id, action, subject, old_value, new_value, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, e.Time, e.Actor, e.Action, e.Subject, e.Before, e.After, e.PrevHash, e.Hash)
+	return err
+}
+
+// AuditQuery selects entries of the audit log for QueryAuditLog. Zero values
+// of the fields mean that they are not restricted.
+type AuditQuery struct {
+	// Actor selects only entries of the user with this name.
+	Actor string
+	// Action selects only entries of this kind.
+	Action string
+	// Subject selects only entries whose subject contains this string.
+	Subject string
+	// Limit is the maximum number of entries returned. If it is ≤ 0, all
+	// matching entries are returned.
+	Limit int
+}
+
+// QueryAuditLog gets the entries of the audit log selected by q, newest first.
+// It returns ErrNotTreasurer if treasurer may not see them.
+func (k *Kasse) QueryAuditLog(treasurer User, q AuditQuery) ([]AuditEntry, error) {
+	if ok, err := k.IsTreasurer(treasurer); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrNotTreasurer
+	}
+
+	var (
+		where []string
+		args  []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if q.Actor != "" {
+		where = append(where, "u.name = "+arg(q.Actor))
+	}
+	if q.Action != "" {
+		where = append(where, "a.action = "+arg(q.Action))
+	}
+	if q.Subject != "" {
+		where = append(where, "a.subject LIKE "+arg("%"+q.Subject+"%"))
+	}
+
+	query := `SELECT a.entry_id, a.time, a.actor_id, u.name AS actor_name, a.action, a.subject, a.old_value, a.new_value, a.prev_hash, a.hash
+		FROM audit_log a LEFT JOIN users u ON a.actor_id = u.user_id`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY a.entry_id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	var entries []AuditEntry
+	if err := k.db.Select(&entries, query, args...); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// GetAuditActions gets all kinds of actions, that exist in the audit log.
+func (k *Kasse) GetAuditActions() ([]string, error) {
+	var actions []string
+	if err := k.db.Select(&actions, `SELECT DISTINCT action FROM audit_log ORDER BY action`); err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+// VerifyAuditLog checks the hash chain of the audit log. It returns an
+// *AuditLogError for the first entry that has been changed, or that follows
+// a removed entry.
+func (k *Kasse) VerifyAuditLog() error {
+	rows, err := k.db.Queryx(`SELECT entry_id, time, actor_id, action, subject, old_value, new_value, prev_hash, hash FROM audit_log ORDER BY entry_id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var prev string
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.StructScan(&e); err != nil {
+			return err
+		}
+		if e.PrevHash != prev {
+			return &AuditLogError{e.ID, "previous entry is missing or has been changed"}
+		}
+		if e.hash(k.auditKey) != e.Hash {
+			return &AuditLogError{e.ID, "entry has been changed"}
+		}
+		prev = e.Hash
+	}
+	return rows.Err()
+}
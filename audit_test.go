@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	defer k.db.Close()
+
+	mero, err := k.RegisterUser("Merovius", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	koebi, err := k.RegisterUser("Koebi", []byte("password1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.PromoteTreasurer("Merovius"); err != nil {
+		t.Fatal(err)
+	}
+	mate, err := k.AddProduct(*mero, "Mate", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mate.Price = 150
+	if err := k.UpdateProduct(*mero, *mate); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetOverdraft(*mero, koebi.ID, sql.NullInt64{Int64: 500, Valid: true}); err != nil {
+		t.Fatal(err)
+	}
+	// Failed changes are not recorded.
+	if err := k.DeleteProduct(*mero, 23); err != ErrProductNotFound {
+		t.Fatalf("DeleteProduct(23) = %v, want %v", err, ErrProductNotFound)
+	}
+
+	if _, err := k.QueryAuditLog(*koebi, AuditQuery{}); err != ErrNotTreasurer {
+		t.Errorf("QueryAuditLog(non-treasurer) = %v, want %v", err, ErrNotTreasurer)
+	}
+
+	entries, err := k.QueryAuditLog(*mero, AuditQuery{})
+	if err != nil {
+		t.Fatalf("QueryAuditLog() = %v", err)
+	}
+	var actions []string
+	for _, e := range entries {
+		actions = append(actions, e.Action)
+	}
+	want := []string{"user.overdraft", "product.update", "product.add", "user.treasurer", "user.register", "user.register"}
+	if !reflect.DeepEqual(actions, want) {
+		t.Errorf("QueryAuditLog() has actions %v, want %v", actions, want)
+	}
+	overdraft := entries[0]
+	if overdraft.ActorName.String != "Merovius" || overdraft.Before != `{"overdraft":null}` || overdraft.After != `{"overdraft":500}` {
+		t.Errorf("overdraft entry is %+v, want one by Merovius from null to 500", overdraft)
+	}
+	if entries[3].Actor.Valid {
+		t.Errorf("user.treasurer entry has actor %v, want none", entries[3].Actor)
+	}
+
+	for _, tc := range []struct {
+		q    AuditQuery
+		want int
+	}{
+		{AuditQuery{Actor: "Merovius"}, 4},
+		{AuditQuery{Action: "user.register"}, 2},
+		{AuditQuery{Subject: "product"}, 2},
+		{AuditQuery{Actor: "Merovius", Limit: 1}, 1},
+	} {
+		if got, err := k.QueryAuditLog(*mero, tc.q); err != nil || len(got) != tc.want {
+			t.Errorf("QueryAuditLog(%+v) returned %d entries, %v, want %d, <nil>", tc.q, len(got), err, tc.want)
+		}
+	}
+
+	if err := k.VerifyAuditLog(); err != nil {
+		t.Fatalf("VerifyAuditLog() = %v, want <nil>", err)
+	}
+
+	if _, err := k.db.Exec(`UPDATE audit_log SET new_value = $1 WHERE entry_id = $2`, `{"overdraft":50000}`, overdraft.ID); err == nil {
+		t.Fatalf("Changing an audit log entry succeeded")
+	}
+
+	// Someone with direct access to the database can remove the triggers,
+	// but the change is detected anyway.
+	drop := []string{`DROP TRIGGER audit_log_no_update`, `DROP TRIGGER audit_log_no_delete`}
+	if isPostgres(k.db) {
+		drop = []string{`DROP TRIGGER audit_log_append_only ON audit_log`}
+	}
+	for _, q := range drop {
+		if _, err := k.db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := k.db.Exec(`UPDATE audit_log SET new_value = $1 WHERE entry_id = $2`, `{"overdraft":50000}`, overdraft.ID); err != nil {
+		t.Fatal(err)
+	}
+	err = k.VerifyAuditLog()
+	if e, ok := err.(*AuditLogError); !ok || e.Entry != overdraft.ID {
+		t.Errorf("VerifyAuditLog() after change = %v, want error for entry %d", err, overdraft.ID)
+	}
+
+	if _, err := k.db.Exec(`DELETE FROM audit_log WHERE entry_id = $1`, entries[2].ID); err != nil {
+		t.Fatal(err)
+	}
+	err = k.VerifyAuditLog()
+	if e, ok := err.(*AuditLogError); !ok || e.Entry != entries[1].ID {
+		t.Errorf("VerifyAuditLog() after removal = %v, want error for entry %d", err, entries[1].ID)
+	}
+}
+
+func TestAuditLogKey(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t), auditKey: []byte("secret")}
+	defer k.db.Close()
+
+	if _, err := k.RegisterUser("Merovius", []byte("password")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.RegisterUser("Koebi", []byte("password1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.VerifyAuditLog(); err != nil {
+		t.Fatalf("VerifyAuditLog() = %v, want <nil>", err)
+	}
+
+	// Without the key, the chain can't be recomputed.
+	k.auditKey = []byte("guessed")
+	if err := k.VerifyAuditLog(); err == nil {
+		t.Errorf("VerifyAuditLog() with wrong key = <nil>, want error")
+	}
+	k.auditKey = nil
+	if err := k.VerifyAuditLog(); err == nil {
+		t.Errorf("VerifyAuditLog() without key = <nil>, want error")
+	}
+}
+
+func TestLoadAuditKey(t *testing.T) {
+	f, err := ioutil.TempFile("", "kasse-audit-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintln(f, "abcd")
+	f.Close()
+
+	os.Setenv(AuditKeyEnv, "0123")
+	defer os.Unsetenv(AuditKeyEnv)
+
+	if key, err := LoadAuditKey(f.Name()); err != nil || !bytes.Equal(key, []byte{0xab, 0xcd}) {
+		t.Errorf("LoadAuditKey(file) == (%x, %v), want (abcd, nil)", key, err)
+	}
+	if key, err := LoadAuditKey(""); err != nil || !bytes.Equal(key, []byte{0x01, 0x23}) {
+		t.Errorf("LoadAuditKey from environment == (%x, %v), want (0123, nil)", key, err)
+	}
+	os.Setenv(AuditKeyEnv, "not hex")
+	if _, err := LoadAuditKey(""); err == nil {
+		t.Errorf("LoadAuditKey of invalid key == (_, nil), want error")
+	}
+	os.Unsetenv(AuditKeyEnv)
+	if key, err := LoadAuditKey(""); err != nil || key != nil {
+		t.Errorf("LoadAuditKey without configuration == (%x, %v), want (nil, nil)", key, err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// adjustBalance adds amount (in cents) to the balance of the user with the
+// given id. Balances are stored in users.balance, so swipes don't have to sum
+// up the whole history of an account. Every insert into transactions has to
+// be accompanied by a call to adjustBalance in the same database transaction.
+// It returns ErrUserNotFound if there is no such user.
+func adjustBalance(tx execer, user int, amount int) error {
+	result, err := tx.Exec(`UPDATE users SET balance = balance + $1 WHERE user_id = $2`, amount, user)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// getBalance reads the balance of the user with the given id.
+func getBalance(q sqlx.Queryer, user int) (int64, error) {
+	var balance int64
+	if err := sqlx.Get(q, &balance, `SELECT balance FROM users WHERE user_id = $1`, user); err == sql.ErrNoRows {
+		return 0, ErrUserNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	return balance, nil
+}
+
+// BalanceDrift is a user whose materialized balance differs from the sum of
+// their transactions.
+type BalanceDrift struct {
+	User    int    `db:"user_id"`
+	Name    string `db:"name"`
+	Balance int64  `db:"balance"`
+	Ledger  int64  `db:"ledger"`
+}
+
+// CheckBalances recomputes the balances of all users from their transactions
+// and returns the users whose stored balance differs.
+func (k *Kasse) CheckBalances() ([]BalanceDrift, error) {
+	var drift []BalanceDrift
+	if err := k.db.Select(&drift, `SELECT u.user_id, u.name, u.balance, COALESCE(SUM(t.amount), 0) AS ledger
+		FROM users u LEFT JOIN transactions t ON t.user_id = u.user_id
+		GROUP BY u.user_id, u.name, u.balance
+		HAVING u.balance != COALESCE(SUM(t.amount), 0)
+		ORDER BY u.user_id`); err != nil {
+		return nil, err
+	}
+	for _, d := range drift {
+		k.log.Printf("Balance of %s is %d, but transactions sum up to %d", d.Name, d.Balance, d.Ledger)
+	}
+	return drift, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestCheckBalances(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	defer k.db.Close()
+
+	mero, err := k.RegisterUser("Merovius", []byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	koebi, err := k.RegisterUser("Koebi", []byte("password1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	insertData(t, k.db, nil, []Card{{ID: []byte("aaaa"), User: mero.ID}}, []Transaction{
+		{ID: 1, User: mero.ID, Time: time.Now().Add(-time.Hour), Amount: 1000, Kind: "Aufladung"},
+	})
+	if _, err := k.AddProduct(User{}, "Mate", 100); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := k.HandleCard(HTTPReaderName, []byte("aaaa"), nil); err != nil {
+		t.Fatalf("HandleCard() = %v", err)
+	}
+	if _, err := k.Transfer(*mero, koebi.Name, 300); err != nil {
+		t.Fatalf("Transfer() = %v", err)
+	}
+	if err := k.UndoLastSwipe(*mero); err != nil {
+		t.Fatalf("UndoLastSwipe() = %v", err)
+	}
+
+	for _, tc := range []struct {
+		user *User
+		want int64
+	}{
+		{mero, 700},
+		{koebi, 300},
+	} {
+		if got, err := k.GetBalance(*tc.user); err != nil || got != tc.want {
+			t.Errorf("GetBalance(%s) = %d, %v, want %d, <nil>", tc.user.Name, got, err, tc.want)
+		}
+	}
+
+	if drift, err := k.CheckBalances(); err != nil || len(drift) != 0 {
+		t.Fatalf("CheckBalances() = %v, %v, want [], <nil>", drift, err)
+	}
+
+	if _, err := k.db.Exec(`UPDATE users SET balance = 0 WHERE user_id = $1`, koebi.ID); err != nil {
+		t.Fatal(err)
+	}
+	drift, err := k.CheckBalances()
+	if err != nil {
+		t.Fatalf("CheckBalances() = %v", err)
+	}
+	want := BalanceDrift{User: koebi.ID, Name: koebi.Name, Balance: 0, Ledger: 300}
+	if len(drift) != 1 || drift[0] != want {
+		t.Errorf("CheckBalances() = %v, want [%v]", drift, want)
+	}
+}
+
+// BenchmarkHandleCard measures swipes on accounts with histories of
+// different lengths. As balances are materialized, the time per swipe should
+// not depend on the length of the history.
+func BenchmarkHandleCard(b *testing.B) {
+	for _, n := range []int{0, 1000, 100000} {
+		b.Run(fmt.Sprintf("history=%d", n), func(b *testing.B) {
+			k := Kasse{db: createDB(b), log: log.New(ioutil.Discard, "", 0)}
+			defer k.db.Close()
+
+			user, err := k.RegisterUser("Merovius", []byte("password"))
+			if err != nil {
+				b.Fatal(err)
+			}
+			uid := []byte("aaaa")
+			if _, err := k.AddCard(uid, user); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := k.AddProduct(User{}, "Mate", 1); err != nil {
+				b.Fatal(err)
+			}
+
+			tx, err := k.db.Beginx()
+			if err != nil {
+				b.Fatal(err)
+			}
+			start := time.Now().Add(-time.Duration(n) * time.Second)
+			for i := 0; i < n; i++ {
+				if _, err := tx.Exec(`INSERT INTO transactions (user_id, card_id, time, amount, kind) VALUES ($1, $2, $3, $4, $5)`, user.ID, uid, start.Add(time.Duration(i)*time.Second), -1, "Kartenswipe"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if _, err := tx.Exec(`INSERT INTO transactions (user_id, time, amount, kind) VALUES ($1, $2, $3, $4)`, user.ID, start, n+b.N, "Aufladung"); err != nil {
+				b.Fatal(err)
+			}
+			if err := adjustBalance(tx, user.ID, b.N); err != nil {
+				b.Fatal(err)
+			}
+			if err := tx.Commit(); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := k.HandleCard(HTTPReaderName, uid, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
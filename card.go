@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// GetCard gets the card with the given UID, if it belongs to owner. It returns
+// ErrCardNotFound otherwise.
+func (k *Kasse) GetCard(uid []byte, owner User) (*Card, error) {
+	card := new(Card)
+	if err := k.db.Get(card, `SELECT card_id, user_id, description, blocked FROM cards WHERE card_id = $1 AND user_id = $2`, uid, owner.ID); err == sql.ErrNoRows {
+		return nil, ErrCardNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return card, nil
+}
+
+// UpdateCard sets the description of the card with the given UID. It returns
+// ErrCardNotFound if there is no such card belonging to owner.
+func (k *Kasse) UpdateCard(uid []byte, owner User, description string) error {
+	k.log.Printf("Setting description of card %x to %q", uid, description)
+	return k.updateCard(uid, owner, "card.update", `UPDATE cards SET description = $1 WHERE card_id = $2 AND user_id = $3`, description, uid, owner.ID)
+}
+
+// SetCardBlocked marks the card with the given UID as blocked (e.g. because it
+// was lost) or unblocks it again. Blocked cards are rejected by HandleCard
+// with ErrCardBlocked. It returns ErrCardNotFound if there is no such card
+// belonging to owner.
+func (k *Kasse) SetCardBlocked(uid []byte, owner User, blocked bool) error {
+	k.log.Printf("Setting blocked of card %x to %v", uid, blocked)
+	return k.updateCard(uid, owner, "card.block", `UPDATE cards SET blocked = $1 WHERE card_id = $2 AND user_id = $3`, blocked, uid, owner.ID)
+}
+
+// RemoveCard deletes the card with the given UID. Transactions made with it
+// are kept. It returns ErrCardNotFound if there is no such card belonging to
+// owner.
+func (k *Kasse) RemoveCard(uid []byte, owner User) error {
+	k.log.Printf("Removing card %x of %s", uid, owner.Name)
+	return k.updateCard(uid, owner, "card.remove", `DELETE FROM cards WHERE card_id = $1 AND user_id = $2`, uid, owner.ID)
+}
+
+// updateCard executes a statement changing the card with the given UID and
+// records it in the audit log under action. It returns ErrCardNotFound if
+// there is no such card belonging to owner.
+func (k *Kasse) updateCard(uid []byte, owner User, action string, query string, args ...interface{}) error {
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	get := func() (interface{}, error) {
+		var c Card
+		if err := tx.Get(&c, `SELECT card_id, user_id, description, blocked FROM cards WHERE card_id = $1 AND user_id = $2`, uid, owner.ID); err == sql.ErrNoRows {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		return cardAuditValue(c), nil
+	}
+
+	before, err := get()
+	if err != nil {
+		return err
+	} else if before == nil {
+		return ErrCardNotFound
+	}
+	if _, err := tx.Exec(query, args...); err != nil {
+		return err
+	}
+	after, err := get()
+	if err != nil {
+		return err
+	}
+	if err := k.audit(tx, owner, action, fmt.Sprintf("card %x", uid), before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// cardAuditValue returns the fields of c recorded in the audit log.
+func cardAuditValue(c Card) interface{} {
+	return map[string]interface{}{
+		"user_id":     c.User,
+		"description": c.Description,
+		"blocked":     c.Blocked,
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCardManagement(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	defer k.db.Close()
+
+	mero := User{ID: 1, Name: "Merovius", Password: []byte("password")}
+	koebi := User{ID: 2, Name: "Koebi", Password: []byte("password1")}
+
+	insertData(t, k.db, []User{mero, koebi}, []Card{
+		{ID: []byte("aaaa"), User: 1},
+		{ID: []byte("baaa"), User: 2},
+	}, []Transaction{
+		{ID: 1, User: 1, Time: time.Now(), Amount: 1000, Kind: "Aufladung"},
+	})
+	if _, err := k.AddProduct(User{}, "Mate", 100); err != nil {
+		t.Fatalf("could not add product: %v", err)
+	}
+
+	if err := k.UpdateCard([]byte("aaaa"), mero, "Schlüsselbund"); err != nil {
+		t.Errorf("UpdateCard(aaaa, Merovius) == %v, want nil", err)
+	}
+	if c, err := k.GetCard([]byte("aaaa"), mero); err != nil || c.Description != "Schlüsselbund" {
+		t.Errorf("GetCard(aaaa, Merovius) == (%v, %v), want description Schlüsselbund", c, err)
+	}
+
+	// Nobody may touch cards of other users.
+	if _, err := k.GetCard([]byte("aaaa"), koebi); err != ErrCardNotFound {
+		t.Errorf("GetCard(aaaa, Koebi) == (_, %v), want (_, %v)", err, ErrCardNotFound)
+	}
+	if err := k.UpdateCard([]byte("aaaa"), koebi, "mine"); err != ErrCardNotFound {
+		t.Errorf("UpdateCard(aaaa, Koebi) == %v, want %v", err, ErrCardNotFound)
+	}
+	if err := k.SetCardBlocked([]byte("aaaa"), koebi, true); err != ErrCardNotFound {
+		t.Errorf("SetCardBlocked(aaaa, Koebi) == %v, want %v", err, ErrCardNotFound)
+	}
+	if err := k.RemoveCard([]byte("aaaa"), koebi); err != ErrCardNotFound {
+		t.Errorf("RemoveCard(aaaa, Koebi) == %v, want %v", err, ErrCardNotFound)
+	}
+
+	if err := k.SetCardBlocked([]byte("aaaa"), mero, true); err != nil {
+		t.Errorf("SetCardBlocked(aaaa, Merovius, true) == %v, want nil", err)
+	}
+	if _, err := k.HandleCard("", []byte("aaaa"), nil); err != ErrCardBlocked {
+		t.Errorf("HandleCard(aaaa) of blocked card == (_, %v), want (_, %v)", err, ErrCardBlocked)
+	}
+	if b, err := k.GetBalance(mero); err != nil || b != 1000 {
+		t.Errorf("GetBalance(Merovius) == (%v, %v), want (1000, nil)", b, err)
+	}
+
+	if err := k.SetCardBlocked([]byte("aaaa"), mero, false); err != nil {
+		t.Errorf("SetCardBlocked(aaaa, Merovius, false) == %v, want nil", err)
+	}
+	if _, err := k.HandleCard("", []byte("aaaa"), nil); err != nil {
+		t.Errorf("HandleCard(aaaa) of unblocked card == (_, %v), want (_, nil)", err)
+	}
+
+	if err := k.RemoveCard([]byte("aaaa"), mero); err != nil {
+		t.Errorf("RemoveCard(aaaa, Merovius) == %v, want nil", err)
+	}
+	if _, err := k.HandleCard("", []byte("aaaa"), nil); err != ErrCardNotFound {
+		t.Errorf("HandleCard(aaaa) of removed card == (_, %v), want (_, %v)", err, ErrCardNotFound)
+	}
+	if cs, err := k.GetCards(mero); err != nil || len(cs) != 0 {
+		t.Errorf("GetCards(Merovius) == (%v, %v), want ([], nil)", cs, err)
+	}
+	if cs, err := k.GetCards(koebi); err != nil || len(cs) != 1 {
+		t.Errorf("GetCards(Koebi) == (%v, %v), want one card", cs, err)
+	}
+}
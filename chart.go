@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+)
+
+// Dimensions of a bar chart in SVG user units. Charts scale to the width of
+// their container.
+const (
+	chartWidth       = 600
+	chartHeight      = 160
+	chartValueHeight = 16
+	chartLabelHeight = 20
+)
+
+// Bar is one bar of a chart rendered by barChart.
+type Bar struct {
+	Label string
+	Value float64
+	// Text is shown above the bar, if it is not empty.
+	Text string
+}
+
+// barChart renders bars as an inline SVG image, scaled to the largest value.
+// Charts are rendered on the server, so the statistics don't need any
+// JavaScript.
+func barChart(bars []Bar) template.HTML {
+	var max float64
+	for _, b := range bars {
+		if b.Value > max {
+			max = b.Value
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" class="chart" viewBox="0 0 %d %d" font-family="sans-serif" font-size="10">`, chartWidth, chartValueHeight+chartHeight+chartLabelHeight)
+	w := float64(chartWidth) / float64(len(bars))
+	for i, b := range bars {
+		var h float64
+		if max > 0 && b.Value > 0 {
+			h = b.Value / max * chartHeight
+		}
+		x := float64(i) * w
+		y := chartValueHeight + chartHeight - h
+		label, text := template.HTMLEscapeString(b.Label), template.HTMLEscapeString(b.Text)
+		fmt.Fprintf(&buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="#3f51b5"><title>%s: %s</title></rect>`, x+w*0.1, y, w*0.8, h, label, text)
+		if text != "" {
+			fmt.Fprintf(&buf, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`, x+w/2, y-4, text)
+		}
+		fmt.Fprintf(&buf, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`, x+w/2, chartValueHeight+chartHeight+14, label)
+	}
+	buf.WriteString(`</svg>`)
+	return template.HTML(buf.String())
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBarChart(t *testing.T) {
+	t.Parallel()
+
+	svg := string(barChart([]Bar{
+		{Label: "Mate", Value: 2, Text: "2"},
+		{Label: "<b>Bier</b>", Value: 4, Text: "4"},
+		{Label: "Kaffee"},
+	}))
+
+	if n := strings.Count(svg, "<rect"); n != 3 {
+		t.Errorf("barChart() has %d bars, want 3", n)
+	}
+	if strings.Contains(svg, "<b>") || !strings.Contains(svg, "&lt;b&gt;Bier&lt;/b&gt;") {
+		t.Errorf("barChart() doesn't escape labels:\n%s", svg)
+	}
+	// The largest bar spans the whole height, the others are scaled.
+	for _, want := range []string{`height="160.0"`, `height="80.0"`, `height="0.0"`} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("barChart() does not contain %s:\n%s", want, svg)
+		}
+	}
+}
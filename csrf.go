@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+)
+
+// csrfFieldName is the name of the form field containing the CSRF token.
+const csrfFieldName = "csrf_token"
+
+// csrfHeader is the header, that can contain the CSRF token instead of the
+// form field.
+const csrfHeader = "X-CSRF-Token"
+
+// csrfContextKey is the key of the CSRF token in the context of a request.
+type csrfContextKey struct{}
+
+// csrfToken returns the CSRF token of the session of req, as stored by
+// csrfMiddleware. It is empty, if req didn't pass the middleware.
+func csrfToken(req *http.Request) string {
+	s, _ := req.Context().Value(csrfContextKey{}).(string)
+	return s
+}
+
+// csrfField returns a hidden form field containing token.
+func csrfField(token string) template.HTML {
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s" />`, csrfFieldName, template.HTMLEscapeString(token)))
+}
+
+// safeMethod returns whether requests with method m must not change any
+// state.
+func safeMethod(m string) bool {
+	return m == "GET" || m == "HEAD" || m == "OPTIONS"
+}
+
+// csrfMiddleware makes sure every session has a CSRF token and rejects all
+// state-changing requests, that don't contain it in the form field
+// csrf_token or the X-CSRF-Token header. JSON requests are exempt, as
+// browsers can't send them cross-origin without a preflight request, which we
+// never allow.
+func (k *Kasse) csrfMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		session, _ := k.sessions.Get(req, "nnev-kasse")
+		token, _ := session.Values["csrf"].(string)
+		if token == "" {
+			b := make([]byte, 32)
+			if _, err := rand.Read(b); err != nil {
+				k.log.Println("Could not create CSRF token:", err)
+				http.Error(res, "Internal error", http.StatusInternalServerError)
+				return
+			}
+			token = base64.RawURLEncoding.EncodeToString(b)
+			session.Values["csrf"] = token
+			if err := session.Save(req, res); err != nil {
+				k.log.Printf("Error saving session: %v", err)
+			}
+		}
+
+		if !safeMethod(req.Method) && !isJSON(req) {
+			got := req.Header.Get(csrfHeader)
+			if got == "" {
+				got = req.PostFormValue(csrfFieldName)
+			}
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				k.log.Printf("Rejecting %s %s with invalid CSRF token", req.Method, req.URL.Path)
+				k.renderError(res, http.StatusForbidden, "Die Anfrage ist ungültig oder abgelaufen. Bitte lade die Seite neu und versuche es noch einmal.")
+				return
+			}
+		}
+
+		h.ServeHTTP(res, req.WithContext(context.WithValue(req.Context(), csrfContextKey{}, token)))
+	})
+}
+
+// isJSON returns whether the body of req is declared as JSON.
+func isJSON(req *http.Request) bool {
+	t, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return err == nil && t == "application/json"
+}
+
+// renderError renders an error page with the given status and message.
+func (k *Kasse) renderError(res http.ResponseWriter, status int, message string) {
+	res.Header().Set("Content-Type", "text/html")
+	res.WriteHeader(status)
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Fehler", Body: "error.html", Data: message}); err != nil {
+		k.log.Println("Could not render template:", err)
+	}
+}
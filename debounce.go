@@ -0,0 +1,49 @@
+package main
+
+import "time"
+
+// debouncer keeps track of the cards present at a reader. A reader reports a
+// card on every poll for as long as it stays in the field, so a card is only
+// accepted again after it has not been seen for timeout.
+type debouncer struct {
+	timeout time.Duration
+	now     func() time.Time
+	seen    map[string]time.Time
+}
+
+func newDebouncer(timeout time.Duration) *debouncer {
+	return &debouncer{
+		timeout: timeout,
+		now:     time.Now,
+		seen:    make(map[string]time.Time),
+	}
+}
+
+// accept records that uid has been seen at reader and returns whether it has
+// just arrived there, i.e. whether it should be charged.
+func (d *debouncer) accept(reader string, uid []byte) bool {
+	now := d.now()
+	for k, t := range d.seen {
+		if now.Sub(t) >= d.timeout {
+			delete(d.seen, k)
+		}
+	}
+
+	key := reader + "\x00" + string(uid)
+	_, present := d.seen[key]
+	d.seen[key] = now
+	return !present
+}
+
+// Debounce forwards all events from in to out, except for repeated reports of
+// a card that stays on a reader. A card is forwarded again only after it has
+// been removed from that reader for at least timeout. Errors are always
+// forwarded. Debounce returns when in is closed.
+func Debounce(in <-chan NFCEvent, out chan<- NFCEvent, timeout time.Duration) {
+	d := newDebouncer(timeout)
+	for ev := range in {
+		if ev.Err != nil || d.accept(ev.Reader, ev.UID) {
+			out <- ev
+		}
+	}
+}
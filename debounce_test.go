@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDebounce(t *testing.T) {
+	t.Parallel()
+
+	// Every entry is one poll of the reader, 100ms apart. A nil UID means no
+	// card was in the field.
+	reader := TestReader{
+		{UID: []byte("aaaa")},
+		{UID: []byte("aaaa")},
+		{UID: []byte("aaaa")},
+		{UID: nil},
+		{UID: []byte("aaaa")},
+		{UID: []byte("baaa")},
+		{UID: []byte("baaa")},
+		{UID: nil},
+		{UID: nil},
+		{UID: nil},
+		{UID: []byte("aaaa")},
+		{UID: nil},
+		{UID: []byte("baaa")},
+	}
+
+	var now time.Time
+	d := newDebouncer(300 * time.Millisecond)
+	d.now = func() time.Time { return now }
+
+	var got [][]byte
+	for {
+		uid, err := reader.GetNextUID()
+		if err != nil {
+			break
+		}
+		now = now.Add(100 * time.Millisecond)
+		if uid != nil && d.accept("test", uid) {
+			got = append(got, uid)
+		}
+	}
+
+	want := [][]byte{[]byte("aaaa"), []byte("baaa"), []byte("aaaa"), []byte("baaa")}
+	if len(got) != len(want) {
+		t.Fatalf("accepted %q, want %q", got, want)
+	}
+	for i := range got {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("accepted %q, want %q", got, want)
+		}
+	}
+}
+
+func TestDebounceForwardsErrors(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan NFCEvent)
+	out := make(chan NFCEvent, 4)
+	go func() {
+		in <- NFCEvent{UID: []byte("aaaa")}
+		in <- NFCEvent{UID: []byte("aaaa")}
+		in <- NFCEvent{Err: errors.New("reader broke")}
+		in <- NFCEvent{Err: errors.New("reader broke")}
+		close(in)
+	}()
+	Debounce(in, out, time.Hour)
+	close(out)
+
+	var uids, errs int
+	for ev := range out {
+		if ev.Err != nil {
+			errs++
+		} else {
+			uids++
+		}
+	}
+	if uids != 1 || errs != 2 {
+		t.Errorf("Debounce forwarded %d cards and %d errors, want 1 and 2", uids, errs)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/Merovius/go-misc/lcd2usb"
+)
+
+// Display shows messages to the user standing at the reader.
+type Display interface {
+	// Show shows text with the given background color for d, before
+	// returning to the idle screen. Lines are separated by '\n'.
+	Show(text string, r, g, b uint8, d time.Duration) error
+}
+
+// flash shows text on d for the configured display time.
+func flash(d Display, text string, r, g, b uint8) error {
+	return d.Show(text, r, g, b, *displayTime)
+}
+
+// LCDDisplay is a Display for a 16x2 LCD connected via lcd2usb.
+type LCDDisplay struct {
+	*lcd2usb.Device
+}
+
+// Show implements Display.
+func (lcd LCDDisplay) Show(text string, r, g, b uint8, d time.Duration) error {
+	lcd.Color(r, g, b)
+	for i, l := range strings.Split(text, "\n") {
+		if len(l) > 16 {
+			l = l[:16]
+		}
+		if i > 2 {
+			break
+		}
+		lcd.CursorPosition(1, uint8(i+1))
+		fmt.Fprint(lcd, l)
+	}
+	time.Sleep(d)
+	lcd.Color(0, 0, 255)
+	lcd.Clear()
+	return nil
+}
+
+// NopDisplay is a Display that discards all messages.
+type NopDisplay struct{}
+
+// Show implements Display.
+func (NopDisplay) Show(text string, r, g, b uint8, d time.Duration) error {
+	return nil
+}
+
+// LogDisplay is a Display that writes all messages to a log.
+type LogDisplay struct {
+	*log.Logger
+}
+
+// Show implements Display.
+func (l LogDisplay) Show(text string, r, g, b uint8, d time.Duration) error {
+	l.Printf("Display (#%02x%02x%02x): %q", r, g, b, text)
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type shown struct {
+	text    string
+	r, g, b uint8
+}
+
+type testDisplay []shown
+
+func (t *testDisplay) Show(text string, r, g, b uint8, d time.Duration) error {
+	*t = append(*t, shown{text, r, g, b})
+	return nil
+}
+
+func TestResultPrint(t *testing.T) {
+	t.Parallel()
+
+	tcs := []struct {
+		code    ResultCode
+		r, g, b uint8
+	}{
+		{PaymentMade, 0, 255, 0},
+		{LowBalance, 255, 50, 0},
+		{AccountEmpty, 255, 0, 0},
+		{Overdrawn, 255, 0, 255},
+		{SwipeUndone, 0, 255, 255},
+	}
+
+	for _, tc := range tcs {
+		var d testDisplay
+		res := &Result{Code: tc.code, UID: []byte("aaaa"), User: "Merovius", Account: 4.2}
+		if err := res.Print(&d); err != nil {
+			t.Errorf("Print() of %v == %v, want nil", tc.code, err)
+			continue
+		}
+		if len(d) != 1 {
+			t.Errorf("Print() of %v showed %d messages, want 1", tc.code, len(d))
+			continue
+		}
+		if got := d[0]; got.r != tc.r || got.g != tc.g || got.b != tc.b {
+			t.Errorf("Print() of %v shows color (%d, %d, %d), want (%d, %d, %d)", tc.code, got.r, got.g, got.b, tc.r, tc.g, tc.b)
+		}
+		if !strings.Contains(d[0].text, "Merovius") {
+			t.Errorf("Print() of %v shows %q, want user name", tc.code, d[0].text)
+		}
+		if undone := strings.Contains(d[0].text, "UNDONE"); undone != (tc.code == SwipeUndone) {
+			t.Errorf("Print() of %v shows %q, want UNDONE only for %v", tc.code, d[0].text, SwipeUndone)
+		}
+	}
+}
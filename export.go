@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportRecord is a transaction as it is exported. Amounts are in cents and
+// times are formatted as RFC 3339.
+type ExportRecord struct {
+	ID      int    `json:"id"`
+	UserID  int    `json:"user_id"`
+	User    string `json:"user"`
+	Card    string `json:"card,omitempty"`
+	Time    string `json:"time"`
+	Amount  int    `json:"amount"`
+	Kind    string `json:"kind"`
+	Product string `json:"product,omitempty"`
+	Balance int64  `json:"balance"`
+}
+
+// exportHeader is the header line of CSV exports.
+var exportHeader = []string{"id", "user_id", "user", "card", "time", "amount", "kind", "product", "balance"}
+
+// ExportTransactions gets all transactions of the given users, as they are
+// exported.
+func (k *Kasse) ExportTransactions(users ...User) ([]ExportRecord, error) {
+	var records []ExportRecord
+	for _, u := range users {
+		transactions, err := k.GetTransactions(u, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range transactions {
+			r := ExportRecord{
+				ID:      t.ID,
+				UserID:  u.ID,
+				User:    u.Name,
+				Time:    t.Time.Format(time.RFC3339),
+				Amount:  t.Amount,
+				Kind:    t.Kind,
+				Product: t.ProductName.String,
+				Balance: t.Balance,
+			}
+			if t.Card != nil {
+				r.Card = fmt.Sprintf("%x", t.Card)
+			}
+			records = append(records, r)
+		}
+	}
+	return records, nil
+}
+
+// csvText escapes a text cell of a CSV export. Spreadsheets evaluate cells
+// starting with one of =+-@, a tab or a carriage return as formulas, so users
+// could put formulas into exports via their names, product names or the like.
+// Such cells are prefixed with a single quote.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// WriteCSV writes records as CSV with a header line to w. Text cells are
+// escaped with csvText, numbers are written as they are.
+func WriteCSV(w io.Writer, records []ExportRecord) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if err := cw.Write([]string{
+			strconv.Itoa(r.ID),
+			strconv.Itoa(r.UserID),
+			csvText(r.User),
+			csvText(r.Card),
+			r.Time,
+			strconv.Itoa(r.Amount),
+			csvText(r.Kind),
+			csvText(r.Product),
+			strconv.FormatInt(r.Balance, 10),
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes records as a JSON array to w.
+func WriteJSON(w io.Writer, records []ExportRecord) error {
+	if records == nil {
+		records = []ExportRecord{}
+	}
+	return json.NewEncoder(w).Encode(records)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExportTransactions(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	defer k.db.Close()
+
+	mero := User{ID: 1, Name: "Merovius", Password: []byte("password")}
+	koebi := User{ID: 2, Name: "Koebi", Password: []byte("password1")}
+
+	insertData(t, k.db, []User{mero, koebi}, []Card{
+		{ID: []byte("aaaa"), User: 1},
+	}, []Transaction{
+		{ID: 1, User: 1, Time: time.Date(2015, 4, 6, 20, 59, 3, 0, time.UTC), Amount: 1000, Kind: "Aufladung"},
+		{ID: 2, User: 1, Card: []byte("aaaa"), Time: time.Date(2015, 4, 6, 21, 5, 27, 0, time.UTC), Amount: -150, Kind: "Kartenswipe"},
+		{ID: 3, User: 2, Time: time.Date(2015, 4, 7, 10, 0, 0, 0, time.UTC), Amount: 505, Kind: "Aufladung"},
+	})
+
+	records, err := k.ExportTransactions(mero, koebi)
+	if err != nil {
+		t.Fatalf("ExportTransactions() == (_, %v), want (_, nil)", err)
+	}
+
+	want := []ExportRecord{
+		{ID: 2, UserID: 1, User: "Merovius", Card: "61616161", Time: "2015-04-06T21:05:27Z", Amount: -150, Kind: "Kartenswipe", Balance: 850},
+		{ID: 1, UserID: 1, User: "Merovius", Time: "2015-04-06T20:59:03Z", Amount: 1000, Kind: "Aufladung", Balance: 1000},
+		{ID: 3, UserID: 2, User: "Koebi", Time: "2015-04-07T10:00:00Z", Amount: 505, Kind: "Aufladung", Balance: 505},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Fatalf("ExportTransactions() == %+v, want %+v", records, want)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := WriteCSV(buf, records[:2]); err != nil {
+		t.Fatalf("WriteCSV() == %v, want nil", err)
+	}
+	wantCSV := "id,user_id,user,card,time,amount,kind,product,balance\n" +
+		"2,1,Merovius,61616161,2015-04-06T21:05:27Z,-150,Kartenswipe,,850\n" +
+		"1,1,Merovius,,2015-04-06T20:59:03Z,1000,Aufladung,,1000\n"
+	if got := buf.String(); got != wantCSV {
+		t.Errorf("WriteCSV() wrote\n%s\nwant\n%s", got, wantCSV)
+	}
+
+	buf.Reset()
+	if err := WriteJSON(buf, records); err != nil {
+		t.Fatalf("WriteJSON() == %v, want nil", err)
+	}
+	var got []ExportRecord
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("WriteJSON() wrote invalid JSON %q: %v", buf.String(), err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WriteJSON() round-trips to %+v, want %+v", got, want)
+	}
+
+	buf.Reset()
+	if err := WriteJSON(buf, nil); err != nil || buf.String() != "[]\n" {
+		t.Errorf("WriteJSON(nil) wrote (%q, %v), want ([], nil)", buf.String(), err)
+	}
+}
+
+func TestWriteCSVEscapesFormulas(t *testing.T) {
+	t.Parallel()
+
+	records := []ExportRecord{
+		{ID: 1, UserID: 1, User: "=HYPERLINK(\"http://evil\")", Amount: -150, Kind: "Kartenswipe", Product: "+1"},
+		{ID: 2, UserID: 2, User: "@SUM(A1)", Amount: 100, Kind: "-x", Product: "\tMate"},
+		{ID: 3, UserID: 3, User: "\rKoebi", Amount: 0, Kind: "Aufladung", Product: "Mate-Cola"},
+	}
+	buf := new(bytes.Buffer)
+	if err := WriteCSV(buf, records); err != nil {
+		t.Fatalf("WriteCSV() == %v, want nil", err)
+	}
+	want := "id,user_id,user,card,time,amount,kind,product,balance\n" +
+		"1,1,\"'=HYPERLINK(\"\"http://evil\"\")\",,,-150,Kartenswipe,'+1,0\n" +
+		"2,2,'@SUM(A1),,,100,'-x,'\tMate,0\n" +
+		"3,3,\"'\rKoebi\",,,0,Aufladung,Mate-Cola,0\n"
+	if got := buf.String(); got != want {
+		t.Errorf("WriteCSV() wrote\n%q\nwant\n%q", got, want)
+	}
+}
@@ -2,16 +2,177 @@ package main
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 )
 
+// sessionUser returns the user that is logged in in the session of req. ok is
+// false, if there is none or the password of the user changed after the login.
+func (k *Kasse) sessionUser(req *http.Request) (user User, ok bool) {
+	session, err := k.sessions.Get(req, "nnev-kasse")
+	if err != nil {
+		return User{}, false
+	}
+	ui, ok := session.Values["user"]
+	if !ok {
+		return User{}, false
+	}
+	user, ok = ui.(User)
+	if !ok {
+		return User{}, false
+	}
+
+	// The session is only valid, as long as the password didn't change since
+	// the login.
+	var password []byte
+	if err := k.db.Get(&password, `SELECT password FROM users WHERE user_id = $1`, user.ID); err != nil {
+		return User{}, false
+	}
+	if !bytes.Equal(password, user.Password) {
+		return User{}, false
+	}
+	return user, true
+}
+
+// requireTreasurer returns the logged in user, if it is a treasurer. Otherwise
+// it redirects to the login page or responds with an error and ok is false.
+func (k *Kasse) requireTreasurer(res http.ResponseWriter, req *http.Request) (user User, ok bool) {
+	user, ok = k.sessionUser(req)
+	if !ok {
+		http.Redirect(res, req, "/login.html", 302)
+		return User{}, false
+	}
+
+	if ok, err := k.IsTreasurer(user); err != nil {
+		k.log.Printf("Could not check if %q is a treasurer: %v", user.Name, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return User{}, false
+	} else if !ok {
+		http.Error(res, "Only treasurers can do that", http.StatusForbidden)
+		return User{}, false
+	}
+	return user, true
+}
+
+// remoteIP returns the IP address of the client of req. Behind a reverse
+// proxy, all requests come from the proxy, so if it is one of the trusted
+// proxies, the address it forwarded is used instead: the last one in
+// X-Forwarded-For, that is not a trusted proxy itself (the ones before it can
+// be made up by the client), or else X-Real-IP.
+func (k *Kasse) remoteIP(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+	if !k.trustedProxy(ip) {
+		return ip
+	}
+
+	fwd := strings.Split(strings.Join(req.Header["X-Forwarded-For"], ","), ",")
+	for i := len(fwd) - 1; i >= 0; i-- {
+		if f := strings.TrimSpace(fwd[i]); f != "" && !k.trustedProxy(f) {
+			return f
+		}
+	}
+	if real := strings.TrimSpace(req.Header.Get("X-Real-IP")); real != "" {
+		return real
+	}
+	return ip
+}
+
+// trustedProxy returns whether ip is in one of k.trustedProxies.
+func (k *Kasse) trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range k.trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses a comma-separated list of IP addresses and CIDR
+// networks.
+func parseTrustedProxies(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if !strings.Contains(f, "/") {
+			if ip := net.ParseIP(f); ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", f)
+			} else if ip.To4() != nil {
+				f += "/32"
+			} else {
+				f += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", f)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// redactTokens hides the value of the query parameter token in the RequestURI
+// of requests, before passing them on to h. The access log writes the
+// RequestURI, but kiosk and password reset tokens must not end up in it. The
+// handlers read the token from req.URL, which is kept as it is.
+func redactTokens(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if q := req.URL.Query(); q.Get("token") != "" {
+			q.Set("token", "REDACTED")
+			u := *req.URL
+			u.RawQuery = q.Encode()
+			req.RequestURI = u.RequestURI()
+		}
+		h.ServeHTTP(res, req)
+	})
+}
+
+// parseEuros parses a user-supplied amount of euros like "5", "2.50" or "2,5"
+// and returns it in cents. Negative amounts are not accepted.
+func parseEuros(s string) (int, error) {
+	s = strings.Replace(strings.TrimSpace(s), ",", ".", 1)
+	euros, cents := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		euros, cents = s[:i], s[i+1:]
+	}
+	if euros == "" || len(cents) > 2 || strings.ContainsAny(euros+cents, "+-") {
+		return 0, errors.New("invalid amount")
+	}
+	for len(cents) < 2 {
+		cents += "0"
+	}
+	e, err := strconv.Atoi(euros)
+	if err != nil {
+		return 0, errors.New("invalid amount")
+	}
+	c, err := strconv.Atoi(cents)
+	if err != nil {
+		return 0, errors.New("invalid amount")
+	}
+	return 100*e + c, nil
+}
+
 // GetLoginPage renders the login page to the user.
 func (k *Kasse) GetLoginPage(res http.ResponseWriter, req *http.Request) {
 	res.Header().Set("Content-Type", "text/html")
 
-	if err := ExecuteTemplate(res, TemplateInput{Title: "Login", Body: "login.html"}); err != nil {
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Login", Body: "login.html", CSRFToken: csrfToken(req)}); err != nil {
 		k.log.Println("Could not render template:", err)
 		http.Error(res, "Internal error", http.StatusInternalServerError)
 		return
@@ -33,7 +194,11 @@ func (k *Kasse) PostLoginPage(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	user, err := k.Authenticate(username, password)
+	user, err := k.Login(username, password, k.remoteIP(req))
+	if err == ErrTooManyAttempts {
+		http.Error(res, "Too many failed logins, try again later", http.StatusTooManyRequests)
+		return
+	}
 	if err != nil && err != ErrWrongAuth {
 		k.log.Println("Error authenticating:", err)
 		// TODO: Write own Error function, that uses a template for better
@@ -69,7 +234,7 @@ func (k *Kasse) PostLoginPage(res http.ResponseWriter, req *http.Request) {
 func (k *Kasse) GetNewUserPage(res http.ResponseWriter, req *http.Request) {
 	res.Header().Set("Content-Type", "text/html")
 
-	if err := ExecuteTemplate(res, TemplateInput{Title: "Create new user", Body: "newUser.html"}); err != nil {
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Create new user", Body: "newUser.html", CSRFToken: csrfToken(req)}); err != nil {
 		k.log.Println("Could not render template:", err)
 		http.Error(res, "Internal error", http.StatusInternalServerError)
 		return
@@ -134,17 +299,11 @@ func (k *Kasse) PostNewUserPage(res http.ResponseWriter, req *http.Request) {
 // GetDashboard renders a basic dashboard, containing the most important
 // information and actions for an account.
 func (k *Kasse) GetDashboard(res http.ResponseWriter, req *http.Request) {
-	session, err := k.sessions.Get(req, "nnev-kasse")
-	if err != nil {
-		http.Redirect(res, req, "/login.html", 302)
-		return
-	}
-	ui, ok := session.Values["user"]
+	user, ok := k.sessionUser(req)
 	if !ok {
 		http.Redirect(res, req, "/login.html", 302)
 		return
 	}
-	user := ui.(User)
 
 	cards, err := k.GetCards(user)
 	if err != nil {
@@ -167,6 +326,27 @@ func (k *Kasse) GetDashboard(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	topups, err := k.GetPendingTopUpsFor(user)
+	if err != nil {
+		k.log.Printf("Could not get pending top-ups for user %q: %v", user.Name, err)
+		http.Error(res, "Internal error", 500)
+		return
+	}
+
+	treasurer, err := k.IsTreasurer(user)
+	if err != nil {
+		k.log.Printf("Could not check if %q is a treasurer: %v", user.Name, err)
+		http.Error(res, "Internal error", 500)
+		return
+	}
+
+	undo, err := k.GetUndoableSwipe(user)
+	if err != nil && err != ErrNothingToUndo {
+		k.log.Printf("Could not get undoable swipe for user %q: %v", user.Name, err)
+		http.Error(res, "Internal error", 500)
+		return
+	}
+
 	res.Header().Set("Content-Type", "text/html")
 
 	data := struct {
@@ -174,14 +354,20 @@ func (k *Kasse) GetDashboard(res http.ResponseWriter, req *http.Request) {
 		Balance      float32
 		Cards        []Card
 		Transactions []Transaction
+		TopUps       []TopUp
+		Treasurer    bool
+		Undo         *Transaction
 	}{
 		User:         user,
 		Balance:      float32(balance) / 100,
 		Cards:        cards,
 		Transactions: transactions,
+		TopUps:       topups,
+		Treasurer:    treasurer,
+		Undo:         undo,
 	}
 
-	if err := ExecuteTemplate(res, TemplateInput{Title: "ccchd Kasse", Body: "dashboard.html", Data: data}); err != nil {
+	if err := ExecuteTemplate(res, TemplateInput{Title: "ccchd Kasse", Body: "dashboard.html", Data: data, CSRFToken: csrfToken(req)}); err != nil {
 		k.log.Println("Could not render template:", err)
 		http.Error(res, "Internal error", 500)
 		return
@@ -206,6 +392,7 @@ func (k *Kasse) GetLogout(res http.ResponseWriter, req *http.Request) {
 // Handler returns a http.Handler for the webinterface.
 func (k *Kasse) Handler() http.Handler {
 	r := mux.NewRouter()
+	r.Use(k.csrfMiddleware)
 	r.Methods("GET").Path("/").HandlerFunc(k.GetDashboard)
 	r.Methods("GET").PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 	r.Methods("GET").Path("/login.html").HandlerFunc(k.GetLoginPage)
@@ -213,5 +400,43 @@ func (k *Kasse) Handler() http.Handler {
 	r.Methods("GET").Path("/logout.html").HandlerFunc(k.GetLogout)
 	r.Methods("GET").Path("/create_user.html").HandlerFunc(k.GetNewUserPage)
 	r.Methods("POST").Path("/create_user.html").HandlerFunc(k.PostNewUserPage)
+	r.Methods("GET").Path("/add_card.html").HandlerFunc(k.GetAddCardPage)
+	r.Methods("POST").Path("/add_card.html").HandlerFunc(k.PostAddCardPage)
+	r.Methods("POST").Path("/pair_card.html").HandlerFunc(k.PostPairCardPage)
+	r.Methods("GET").Path("/card.html").HandlerFunc(k.GetCardPage)
+	r.Methods("POST").Path("/card.html").HandlerFunc(k.PostCardPage)
+	r.Methods("GET").Path("/transactions.html").HandlerFunc(k.GetTransactionsPage)
+	r.Methods("GET").Path("/transactions.csv").HandlerFunc(k.GetExport)
+	r.Methods("GET").Path("/transactions.json").HandlerFunc(k.GetExport)
+	r.Methods("GET").Path("/stats.html").HandlerFunc(k.GetStatsPage)
+	r.Methods("POST").Path("/topup.html").HandlerFunc(k.PostTopUp)
+	r.Methods("POST").Path("/undo.html").HandlerFunc(k.PostUndo)
+	r.Methods("POST").Path("/transfer.html").HandlerFunc(k.PostTransfer)
+	r.Methods("GET").Path("/password.html").HandlerFunc(k.GetPasswordPage)
+	r.Methods("POST").Path("/password.html").HandlerFunc(k.PostPasswordPage)
+	r.Methods("GET").Path("/reset.html").HandlerFunc(k.GetResetPage)
+	r.Methods("POST").Path("/reset.html").HandlerFunc(k.PostResetPage)
+	r.Methods("GET").Path("/admin").Handler(http.RedirectHandler("/admin/", http.StatusFound))
+	admin := r.PathPrefix("/admin/").Subrouter()
+	admin.Use(k.treasurerMiddleware)
+	admin.Methods("GET").Path("/").HandlerFunc(k.GetAdminPage)
+	admin.Methods("GET").Path("/audit").HandlerFunc(k.GetAuditPage)
+	admin.Methods("GET").Path("/inventory").HandlerFunc(k.GetInventoryPage)
+	admin.Methods("POST").Path("/inventory").HandlerFunc(k.PostInventoryPage)
+	admin.Methods("GET").Path("/topups").HandlerFunc(k.GetTopUpsPage)
+	admin.Methods("POST").Path("/topups").HandlerFunc(k.PostTopUpsPage)
+	admin.Methods("GET").Path("/products").HandlerFunc(k.GetProductsPage)
+	admin.Methods("POST").Path("/products").HandlerFunc(k.PostProductsPage)
+	admin.Methods("GET").Path("/limits").HandlerFunc(k.GetLimitsPage)
+	admin.Methods("POST").Path("/limits").HandlerFunc(k.PostLimitsPage)
+	admin.Methods("GET").Path("/lockouts").HandlerFunc(k.GetLockoutsPage)
+	admin.Methods("POST").Path("/lockouts").HandlerFunc(k.PostLockoutsPage)
+	admin.Methods("POST").Path("/reset_link").HandlerFunc(k.PostResetLinkPage)
+	admin.Methods("GET").Path("/transactions.csv").HandlerFunc(k.GetExportAll)
+	admin.Methods("GET").Path("/transactions.json").HandlerFunc(k.GetExportAll)
+	r.Methods("GET").Path("/kiosk").Handler(k.kioskMiddleware(http.HandlerFunc(k.GetKioskPage)))
+	r.Methods("GET").Path("/kiosk/events").Handler(k.kioskMiddleware(http.HandlerFunc(k.GetKioskEvents)))
+	r.Methods("GET").Path("/kiosk/stock").Handler(k.kioskMiddleware(http.HandlerFunc(k.GetKioskStock)))
+	k.registerAPI(r.PathPrefix("/api/v1").Subrouter())
 	return r
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// treasurerContextKey is the key of the logged in treasurer in the context of
+// a request.
+type treasurerContextKey struct{}
+
+// contextTreasurer returns the treasurer stored by treasurerMiddleware.
+func contextTreasurer(req *http.Request) User {
+	u, _ := req.Context().Value(treasurerContextKey{}).(User)
+	return u
+}
+
+// treasurerMiddleware only passes on requests of treasurers, which can be
+// retrieved with contextTreasurer. It guards the admin area.
+func (k *Kasse) treasurerMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		treasurer, ok := k.requireTreasurer(res, req)
+		if !ok {
+			return
+		}
+		h.ServeHTTP(res, req.WithContext(context.WithValue(req.Context(), treasurerContextKey{}, treasurer)))
+	})
+}
+
+// GetAdminPage renders a list of all users with their balances, cards and
+// last activity, and of all products that should be reordered.
+func (k *Kasse) GetAdminPage(res http.ResponseWriter, req *http.Request) {
+	users, err := k.GetUserOverviews(contextTreasurer(req))
+	if err != nil {
+		k.log.Println("Could not get users:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	lowStock, err := k.GetLowStockProducts()
+	if err != nil {
+		k.log.Println("Could not get low stock products:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+
+	data := struct {
+		Users    []UserOverview
+		LowStock []Product
+	}{users, lowStock}
+
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Verwaltung", Body: "admin.html", Data: data, CSRFToken: csrfToken(req)}); err != nil {
+		k.log.Println("Could not render template:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// auditPageSize is the maximum number of audit log entries shown at once.
+const auditPageSize = 200
+
+// GetAuditPage renders the newest entries of the audit log. They can be
+// filtered by the name of the actor, the action and a part of the subject.
+func (k *Kasse) GetAuditPage(res http.ResponseWriter, req *http.Request) {
+	q := AuditQuery{
+		Actor:   req.FormValue("actor"),
+		Action:  req.FormValue("action"),
+		Subject: req.FormValue("subject"),
+		Limit:   auditPageSize,
+	}
+	entries, err := k.QueryAuditLog(contextTreasurer(req), q)
+	if err != nil {
+		k.log.Println("Could not get audit log:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	actions, err := k.GetAuditActions()
+	if err != nil {
+		k.log.Println("Could not get audit actions:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+
+	data := struct {
+		AuditQuery
+		Actions []string
+		Entries []AuditEntry
+	}{q, actions, entries}
+
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Protokoll", Body: "audit.html", Data: data, CSRFToken: csrfToken(req)}); err != nil {
+		k.log.Println("Could not render template:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+}
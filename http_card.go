@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// parseUID parses the hex-encoded UID of a card, as displayed on the
+// dashboard.
+func parseUID(s string) ([]byte, error) {
+	uid, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(uid) == 0 {
+		return nil, errors.New("invalid UID")
+	}
+	return uid, nil
+}
+
+// GetAddCardPage renders the page to register a new card.
+func (k *Kasse) GetAddCardPage(res http.ResponseWriter, req *http.Request) {
+	if _, ok := k.sessionUser(req); !ok {
+		http.Redirect(res, req, "/login.html", 302)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Karte hinzufügen", Body: "addCard.html", CSRFToken: csrfToken(req)}); err != nil {
+		k.log.Println("Could not render template:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PostAddCardPage receives a POST request with the hex-encoded UID and a
+// description of a card and registers it for the logged in user. It redirects
+// to the dashboard on success.
+func (k *Kasse) PostAddCardPage(res http.ResponseWriter, req *http.Request) {
+	user, ok := k.sessionUser(req)
+	if !ok {
+		http.Redirect(res, req, "/login.html", 302)
+		return
+	}
+
+	uid, err := parseUID(req.FormValue("uid"))
+	if err != nil {
+		// TODO: Write own Error function, that uses a template for better
+		// looking error pages. Also, redirect.
+		http.Error(res, "Invalid UID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := k.AddCard(uid, &user); err == ErrCardExists {
+		http.Error(res, "Card already registered", http.StatusConflict)
+		return
+	} else if err != nil {
+		k.log.Printf("Could not add card %x for user %q: %v", uid, user.Name, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if d := strings.TrimSpace(req.FormValue("description")); d != "" {
+		if err := k.UpdateCard(uid, user, d); err != nil {
+			k.log.Printf("Could not set description of card %x: %v", uid, err)
+			http.Error(res, "Internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.Redirect(res, req, "/", http.StatusFound)
+}
+
+// GetCardPage renders the page to edit a card of the logged in user, given by
+// its hex-encoded UID in the query.
+func (k *Kasse) GetCardPage(res http.ResponseWriter, req *http.Request) {
+	user, ok := k.sessionUser(req)
+	if !ok {
+		http.Redirect(res, req, "/login.html", 302)
+		return
+	}
+
+	uid, err := parseUID(req.FormValue("uid"))
+	if err != nil {
+		http.Error(res, "Invalid UID", http.StatusBadRequest)
+		return
+	}
+
+	card, err := k.GetCard(uid, user)
+	if err == ErrCardNotFound {
+		http.Error(res, "No such card", http.StatusNotFound)
+		return
+	} else if err != nil {
+		k.log.Printf("Could not get card %x: %v", uid, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Karte bearbeiten", Body: "card.html", Data: card, CSRFToken: csrfToken(req)}); err != nil {
+		k.log.Println("Could not render template:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PostCardPage receives a POST request to change a card of the logged in
+// user, given by its hex-encoded UID. The action field is one of "update"
+// (which needs a description), "block", "unblock" or "delete". It redirects
+// to the dashboard on success.
+func (k *Kasse) PostCardPage(res http.ResponseWriter, req *http.Request) {
+	user, ok := k.sessionUser(req)
+	if !ok {
+		http.Redirect(res, req, "/login.html", 302)
+		return
+	}
+
+	uid, err := parseUID(req.FormValue("uid"))
+	if err != nil {
+		http.Error(res, "Invalid UID", http.StatusBadRequest)
+		return
+	}
+
+	action := req.FormValue("action")
+	switch action {
+	case "update":
+		err = k.UpdateCard(uid, user, strings.TrimSpace(req.FormValue("description")))
+	case "block":
+		err = k.SetCardBlocked(uid, user, true)
+	case "unblock":
+		err = k.SetCardBlocked(uid, user, false)
+	case "delete":
+		err = k.RemoveCard(uid, user)
+	default:
+		http.Error(res, "Invalid action", http.StatusBadRequest)
+		return
+	}
+
+	if err == ErrCardNotFound {
+		http.Error(res, "No such card", http.StatusNotFound)
+		return
+	} else if err != nil {
+		k.log.Printf("Could not %s card %x: %v", action, uid, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(res, req, "/", http.StatusFound)
+}
+
+// PostPairCardPage receives a POST request with a pairing code, that has been
+// shown on the display after swiping an unknown card, and registers that card
+// for the logged in user. It redirects to the dashboard on success.
+func (k *Kasse) PostPairCardPage(res http.ResponseWriter, req *http.Request) {
+	user, ok := k.sessionUser(req)
+	if !ok {
+		http.Redirect(res, req, "/login.html", 302)
+		return
+	}
+
+	code := strings.TrimSpace(req.FormValue("code"))
+	if code == "" {
+		http.Error(res, "Pairing code can't be empty", http.StatusBadRequest)
+		return
+	}
+
+	switch _, err := k.PairCard(code, &user); err {
+	case nil:
+	case ErrInvalidPairingCode:
+		http.Error(res, "Invalid or expired pairing code", http.StatusBadRequest)
+		return
+	case ErrCardExists:
+		http.Error(res, "Card already registered", http.StatusConflict)
+		return
+	default:
+		k.log.Printf("Could not pair card for user %q: %v", user.Name, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(res, req, "/", http.StatusFound)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+// exportFormats maps the extension of an export to its content type and
+// writer.
+var exportFormats = map[string]struct {
+	ContentType string
+	Write       func(io.Writer, []ExportRecord) error
+}{
+	"csv":  {"text/csv; charset=utf-8", WriteCSV},
+	"json": {"application/json", WriteJSON},
+}
+
+// writeExport writes records in the format given by the extension of the
+// requested path.
+func (k *Kasse) writeExport(res http.ResponseWriter, req *http.Request, name string, records []ExportRecord) {
+	ext := req.URL.Path[strings.LastIndex(req.URL.Path, ".")+1:]
+	f, ok := exportFormats[ext]
+	if !ok {
+		http.Error(res, "Unknown export format", http.StatusNotFound)
+		return
+	}
+
+	res.Header().Set("Content-Type", f.ContentType)
+	res.Header().Set("Content-Disposition", `attachment; filename="`+name+"."+ext+`"`)
+	if err := f.Write(res, records); err != nil {
+		k.log.Println("Could not write export:", err)
+	}
+}
+
+// GetExport exports all transactions of the logged in user as CSV or JSON,
+// depending on the extension of the path.
+func (k *Kasse) GetExport(res http.ResponseWriter, req *http.Request) {
+	user, ok := k.sessionUser(req)
+	if !ok {
+		http.Redirect(res, req, "/login.html", 302)
+		return
+	}
+
+	records, err := k.ExportTransactions(user)
+	if err != nil {
+		k.log.Printf("Could not export transactions for user %q: %v", user.Name, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	k.writeExport(res, req, "transactions", records)
+}
+
+// GetExportAll exports the transactions of all users as CSV or JSON,
+// depending on the extension of the path.
+func (k *Kasse) GetExportAll(res http.ResponseWriter, req *http.Request) {
+	users, err := k.GetUsers()
+	if err != nil {
+		k.log.Println("Could not get users:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	records, err := k.ExportTransactions(users...)
+	if err != nil {
+		k.log.Println("Could not export transactions:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	k.writeExport(res, req, "all_transactions", records)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// stockChangesPageSize is the maximum number of deliveries and stock-takes
+// shown on the inventory page.
+const stockChangesPageSize = 50
+
+// GetInventoryPage renders the stock of all products with forms to record
+// deliveries and stock-takes, and the newest of those with their shrinkage.
+func (k *Kasse) GetInventoryPage(res http.ResponseWriter, req *http.Request) {
+	products, err := k.GetProducts()
+	if err != nil {
+		k.log.Println("Could not get products:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	changes, err := k.GetStockChanges(stockChangesPageSize)
+	if err != nil {
+		k.log.Println("Could not get stock changes:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+
+	data := struct {
+		Products []Product
+		Changes  []StockChange
+	}{products, changes}
+
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Lager", Body: "inventory.html", Data: data, CSRFToken: csrfToken(req)}); err != nil {
+		k.log.Println("Could not render template:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PostInventoryPage receives a POST request with the id of a product and an
+// action, which is one of "delivery", "stocktake" or "reorder". They need a
+// number of units in the field quantity; for "reorder" it may be empty, to
+// never flag the product. It redirects back to the inventory page on success.
+func (k *Kasse) PostInventoryPage(res http.ResponseWriter, req *http.Request) {
+	treasurer := contextTreasurer(req)
+
+	action := req.FormValue("action")
+
+	id, err := strconv.Atoi(req.FormValue("id"))
+	if err != nil {
+		http.Error(res, "Invalid product id", http.StatusBadRequest)
+		return
+	}
+
+	var quantity sql.NullInt64
+	if s := strings.TrimSpace(req.FormValue("quantity")); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(res, "Invalid quantity", http.StatusBadRequest)
+			return
+		}
+		quantity = sql.NullInt64{Int64: int64(n), Valid: true}
+	} else if action != "reorder" {
+		http.Error(res, "Invalid quantity", http.StatusBadRequest)
+		return
+	}
+
+	switch action {
+	case "delivery":
+		_, err = k.RecordDelivery(treasurer, id, int(quantity.Int64))
+	case "stocktake":
+		_, err = k.RecordStockTake(treasurer, id, int(quantity.Int64))
+	case "reorder":
+		err = k.SetReorderLevel(treasurer, id, quantity)
+	default:
+		http.Error(res, "Invalid action", http.StatusBadRequest)
+		return
+	}
+
+	switch err {
+	case nil:
+	case ErrProductNotFound:
+		http.Error(res, "No such product", http.StatusNotFound)
+		return
+	case ErrInvalidQuantity:
+		http.Error(res, "Invalid quantity", http.StatusBadRequest)
+		return
+	default:
+		k.log.Printf("Could not record %s of product %d: %v", action, id, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(res, req, "/admin/inventory", http.StatusFound)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+var kioskTpl = template.Must(template.New("kiosk.html").Parse(`<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+		<title>Getränkekasse</title>
+		<meta name="viewport" content="width=device-width, initial-scale=1">
+		<style>
+			html, body {
+				margin: 0;
+				height: 100%;
+				font-family: sans-serif;
+				color: #000;
+			}
+			body {
+				display: flex;
+				flex-direction: column;
+				justify-content: center;
+				align-items: center;
+				background: #0000ff;
+				transition: background 0.2s;
+			}
+			#user {
+				font-size: 8vw;
+			}
+			#balance {
+				font-size: 14vw;
+				font-weight: bold;
+			}
+			#idle {
+				font-size: 6vw;
+				color: #fff;
+			}
+			#stock {
+				position: fixed;
+				bottom: 2vh;
+				font-size: 3vw;
+				color: #ff0;
+			}
+			.hidden {
+				display: none;
+			}
+		</style>
+	</head>
+	<body>
+		<div id="idle">Bitte Karte auflegen</div>
+		<div id="user" class="hidden"></div>
+		<div id="balance" class="hidden"></div>
+		<div id="stock"{{ if not .LowStock }} class="hidden"{{ end }}>Bald leer: {{ range $i, $p := .LowStock }}{{ if $i }}, {{ end }}{{ $p.Name }}{{ end }}</div>
+		<script>
+			// Colors match Result.Print.
+			var colors = {
+				PaymentMade: "#00ff00",
+				LowBalance: "#ff3200",
+				AccountEmpty: "#ff0000",
+				Overdrawn: "#ff00ff",
+				SwipeUndone: "#00ffff"
+			};
+			var timeout = {{ .Timeout }};
+			var timer = null;
+
+			function show(ids, visible) {
+				ids.forEach(function(id) {
+					document.getElementById(id).classList.toggle("hidden", !visible);
+				});
+			}
+
+			// Products are sold with every swipe, so the list of products that
+			// are running low is refreshed afterwards.
+			function refreshStock() {
+				var xhr = new XMLHttpRequest();
+				xhr.open("GET", "/kiosk/stock");
+				xhr.onload = function() {
+					if (xhr.status !== 200) {
+						return;
+					}
+					var names = JSON.parse(xhr.responseText);
+					document.getElementById("stock").textContent = "Bald leer: " + names.join(", ");
+					show(["stock"], names.length > 0);
+				};
+				xhr.send();
+			}
+
+			function idle() {
+				document.body.style.background = "#0000ff";
+				show(["user", "balance"], false);
+				show(["idle"], true);
+				refreshStock();
+			}
+
+			var events = new EventSource("/kiosk/events");
+			events.onmessage = function(e) {
+				var ev = JSON.parse(e.data);
+				document.getElementById("user").textContent = ev.code === "SwipeUndone" ? "Storniert: " + ev.user : ev.user;
+				document.getElementById("balance").textContent = ev.balance.toFixed(2) + " €";
+				document.body.style.background = colors[ev.code] || "#ffffff";
+				show(["idle"], false);
+				show(["user", "balance"], true);
+				clearTimeout(timer);
+				timer = setTimeout(idle, timeout);
+			};
+		</script>
+	</body>
+</html>
+`))
+
+// kioskCookie is the cookie the kiosk token is kept in, once the kiosk display
+// has opened /kiosk?token=<token>. Its requests for events and stock then
+// don't carry the token in the URL.
+const kioskCookie = "kasse-kiosk"
+
+// kioskMiddleware only passes on requests, that give the configured kiosk token
+// in the query parameter token or the kiosk cookie. A token from the query is
+// stored in the cookie and the kiosk page redirects to itself without it, so
+// it does not stay in the address bar. The kiosk shows the name and balance of
+// everyone swiping a card, so it must not be public.
+func (k *Kasse) kioskMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if k.kioskToken == "" {
+			http.Error(res, "Kiosk is disabled", http.StatusNotFound)
+			return
+		}
+		token := req.URL.Query().Get("token")
+		fromQuery := token != ""
+		if c, err := req.Cookie(kioskCookie); err == nil && !fromQuery {
+			token = c.Value
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(k.kioskToken)) != 1 {
+			http.Error(res, "Invalid kiosk token", http.StatusForbidden)
+			return
+		}
+		if fromQuery {
+			http.SetCookie(res, &http.Cookie{
+				Name:     kioskCookie,
+				Value:    token,
+				Path:     "/kiosk",
+				MaxAge:   365 * 24 * 60 * 60,
+				Secure:   *cookieSecure,
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+			if req.URL.Path == "/kiosk" {
+				http.Redirect(res, req, "/kiosk", http.StatusFound)
+				return
+			}
+		}
+		h.ServeHTTP(res, req)
+	})
+}
+
+// GetKioskPage renders a full-screen page, that shows the results of all
+// swipes at the readers and the products that are running low.
+func (k *Kasse) GetKioskPage(res http.ResponseWriter, req *http.Request) {
+	lowStock, err := k.GetLowStockProducts()
+	if err != nil {
+		k.log.Println("Could not get low stock products:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+	data := struct {
+		Timeout  int64
+		LowStock []Product
+	}{
+		Timeout:  int64(*displayTime / 1e6),
+		LowStock: lowStock,
+	}
+	if err := kioskTpl.Execute(res, data); err != nil {
+		k.log.Println("Could not render template:", err)
+	}
+}
+
+// GetKioskEvents streams the results of all swipes at the readers as
+// Server-Sent Events, until the client disconnects.
+func (k *Kasse) GetKioskEvents(res http.ResponseWriter, req *http.Request) {
+	f, ok := res.(http.Flusher)
+	if !ok {
+		http.Error(res, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := k.kiosk.subscribe()
+	defer k.kiosk.unsubscribe(ch)
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case ev := <-ch:
+			b, err := json.Marshal(ev)
+			if err != nil {
+				k.log.Println("Could not marshal kiosk event:", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(res, "data: %s\n\n", b); err != nil {
+				return
+			}
+			f.Flush()
+		}
+	}
+}
+
+// GetKioskStock returns the names of all products below their reorder level
+// as a JSON array, for the kiosk display.
+func (k *Kasse) GetKioskStock(res http.ResponseWriter, req *http.Request) {
+	products, err := k.GetLowStockProducts()
+	if err != nil {
+		k.log.Println("Could not get low stock products:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	names := []string{}
+	for _, p := range products {
+		names = append(names, p.Name)
+	}
+	res.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(res).Encode(names); err != nil {
+		k.log.Println("Could not encode low stock products:", err)
+	}
+}
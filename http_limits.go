@@ -0,0 +1,65 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GetLimitsPage renders a list of all users with their overdraft limits.
+func (k *Kasse) GetLimitsPage(res http.ResponseWriter, req *http.Request) {
+	users, err := k.GetUsers()
+	if err != nil {
+		k.log.Println("Could not get users:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+
+	data := struct {
+		Users  []User
+		Limits Limits
+	}{users, k.getLimits()}
+
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Kreditrahmen", Body: "limits.html", Data: data, CSRFToken: csrfToken(req)}); err != nil {
+		k.log.Println("Could not render template:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PostLimitsPage receives a POST request with the id of a user and an
+// overdraft limit in euros. An empty limit resets the user to the default. It
+// redirects back to the list on success.
+func (k *Kasse) PostLimitsPage(res http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.FormValue("id"))
+	if err != nil {
+		http.Error(res, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	var limit sql.NullInt64
+	if s := strings.TrimSpace(req.FormValue("overdraft")); s != "" {
+		cents, err := parseEuros(s)
+		if err != nil {
+			http.Error(res, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = sql.NullInt64{Int64: int64(cents), Valid: true}
+	}
+
+	switch err := k.SetOverdraft(contextTreasurer(req), id, limit); err {
+	case nil:
+	case ErrUserNotFound:
+		http.Error(res, "No such user", http.StatusNotFound)
+		return
+	default:
+		k.log.Printf("Could not set overdraft of user %d: %v", id, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(res, req, "/admin/limits", http.StatusFound)
+}
@@ -0,0 +1,34 @@
+package main
+
+import "net/http"
+
+// GetLockoutsPage renders a list of all usernames and IP addresses with failed
+// logins.
+func (k *Kasse) GetLockoutsPage(res http.ResponseWriter, req *http.Request) {
+	lockouts, err := k.GetLockouts(contextTreasurer(req))
+	if err != nil {
+		k.log.Println("Could not get lockouts:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Login-Sperren", Body: "lockouts.html", Data: lockouts, CSRFToken: csrfToken(req)}); err != nil {
+		k.log.Println("Could not render template:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PostLockoutsPage receives a POST request with the key of a lockout and
+// clears it. It redirects back to the list on success.
+func (k *Kasse) PostLockoutsPage(res http.ResponseWriter, req *http.Request) {
+	if err := k.ClearLockout(contextTreasurer(req), req.FormValue("key")); err != nil {
+		k.log.Printf("Could not clear lockout %q: %v", req.FormValue("key"), err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(res, req, "/admin/lockouts", http.StatusFound)
+}
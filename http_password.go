@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// GetPasswordPage renders the page to change the password of the logged in
+// user.
+func (k *Kasse) GetPasswordPage(res http.ResponseWriter, req *http.Request) {
+	if _, ok := k.sessionUser(req); !ok {
+		http.Redirect(res, req, "/login.html", 302)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Passwort ändern", Body: "password.html", CSRFToken: csrfToken(req)}); err != nil {
+		k.log.Println("Could not render template:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PostPasswordPage receives a POST request with the old password, a new
+// password and its confirmation and changes the password of the logged in
+// user. All other sessions of the user are logged out. It redirects to the
+// dashboard on success.
+func (k *Kasse) PostPasswordPage(res http.ResponseWriter, req *http.Request) {
+	user, ok := k.sessionUser(req)
+	if !ok {
+		http.Redirect(res, req, "/login.html", 302)
+		return
+	}
+
+	old := []byte(req.FormValue("old"))
+	password := []byte(req.FormValue("password"))
+	confirm := []byte(req.FormValue("confirm"))
+
+	if len(password) == 0 {
+		http.Error(res, "Password can't be empty", http.StatusBadRequest)
+		return
+	}
+	if !bytes.Equal(password, confirm) {
+		http.Error(res, "Password and confirmation don't match", http.StatusBadRequest)
+		return
+	}
+
+	u, err := k.ChangePassword(user, old, password)
+	if err == ErrWrongAuth {
+		http.Error(res, "Wrong password", http.StatusUnauthorized)
+		return
+	} else if err == ErrTooManyAttempts {
+		http.Error(res, "Too many failed logins, try again later", http.StatusTooManyRequests)
+		return
+	} else if err != nil {
+		k.log.Printf("Could not change password of user %q: %v", user.Name, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	// Keep the current session valid.
+	session, _ := k.sessions.Get(req, "nnev-kasse")
+	session.Values["user"] = u
+	if err := session.Save(req, res); err != nil {
+		k.log.Printf("Error saving session: %v", err)
+	}
+
+	http.Redirect(res, req, "/", http.StatusFound)
+}
+
+// PostResetLinkPage receives a POST request with the id of a user and renders
+// a one-time link, that the user can use to set a new password.
+func (k *Kasse) PostResetLinkPage(res http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.FormValue("id"))
+	if err != nil {
+		http.Error(res, "Invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	token, err := k.NewResetToken(contextTreasurer(req), id)
+	if err == ErrUserNotFound {
+		http.Error(res, "No such user", http.StatusNotFound)
+		return
+	} else if err != nil {
+		k.log.Printf("Could not create reset token for user %d: %v", id, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	link := url.URL{Scheme: scheme, Host: req.Host, Path: "/reset.html", RawQuery: url.Values{"token": {token}}.Encode()}
+
+	res.Header().Set("Content-Type", "text/html")
+
+	data := struct {
+		Link    string
+		Timeout string
+	}{link.String(), ResetTimeout.String()}
+
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Passwort zurücksetzen", Body: "resetLink.html", Data: data, CSRFToken: csrfToken(req)}); err != nil {
+		k.log.Println("Could not render template:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetResetPage renders the page to set a new password with a reset token.
+func (k *Kasse) GetResetPage(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "text/html")
+
+	data := struct {
+		Token string
+	}{req.FormValue("token")}
+
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Passwort zurücksetzen", Body: "reset.html", Data: data, CSRFToken: csrfToken(req)}); err != nil {
+		k.log.Println("Could not render template:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PostResetPage receives a POST request with a reset token, a new password and
+// its confirmation and sets the password of the user the token belongs to. It
+// redirects to the login page on success.
+func (k *Kasse) PostResetPage(res http.ResponseWriter, req *http.Request) {
+	password := []byte(req.FormValue("password"))
+	confirm := []byte(req.FormValue("confirm"))
+
+	if len(password) == 0 {
+		http.Error(res, "Password can't be empty", http.StatusBadRequest)
+		return
+	}
+	if !bytes.Equal(password, confirm) {
+		http.Error(res, "Password and confirmation don't match", http.StatusBadRequest)
+		return
+	}
+
+	if err := k.ResetPassword(req.FormValue("token"), password); err == ErrInvalidResetToken {
+		http.Error(res, "Invalid or expired link", http.StatusForbidden)
+		return
+	} else if err != nil {
+		k.log.Println("Could not reset password:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(res, req, "/login.html", http.StatusFound)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GetProductsPage renders the product catalogue with forms to edit it.
+func (k *Kasse) GetProductsPage(res http.ResponseWriter, req *http.Request) {
+	products, err := k.GetProducts()
+	if err != nil {
+		k.log.Println("Could not get products:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Produkte", Body: "products.html", Data: products, CSRFToken: csrfToken(req)}); err != nil {
+		k.log.Println("Could not render template:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PostProductsPage receives a POST request to change the product catalogue.
+// The action field is one of "create", "update", "delete" or "default". All
+// but "create" need the id of the product, "create" and "update" need a name
+// and a price in euros. It redirects back to the catalogue on success.
+func (k *Kasse) PostProductsPage(res http.ResponseWriter, req *http.Request) {
+	treasurer := contextTreasurer(req)
+	action := req.FormValue("action")
+
+	var p Product
+	if action != "create" {
+		id, err := strconv.Atoi(req.FormValue("id"))
+		if err != nil {
+			http.Error(res, "Invalid product id", http.StatusBadRequest)
+			return
+		}
+		p.ID = id
+	}
+
+	if action == "create" || action == "update" {
+		p.Name = strings.TrimSpace(req.FormValue("name"))
+		price, err := parseEuros(req.FormValue("price"))
+		if p.Name == "" || err != nil {
+			http.Error(res, "Invalid name or price", http.StatusBadRequest)
+			return
+		}
+		p.Price = price
+	}
+
+	var err error
+	switch action {
+	case "create":
+		_, err = k.AddProduct(treasurer, p.Name, p.Price)
+	case "update":
+		err = k.UpdateProduct(treasurer, p)
+	case "delete":
+		err = k.DeleteProduct(treasurer, p.ID)
+	case "default":
+		err = k.SetDefaultProduct(treasurer, p.ID)
+	default:
+		http.Error(res, "Invalid action", http.StatusBadRequest)
+		return
+	}
+
+	switch err {
+	case nil:
+	case ErrProductNotFound:
+		http.Error(res, "No such product", http.StatusNotFound)
+		return
+	case ErrProductExists:
+		http.Error(res, "Product already exists", http.StatusConflict)
+		return
+	case ErrDefaultProduct:
+		http.Error(res, "The default product can't be deleted, make another one the default first", http.StatusConflict)
+		return
+	case ErrProductInUse:
+		http.Error(res, "Product has already been sold or stocked and can't be deleted", http.StatusConflict)
+		return
+	default:
+		k.log.Printf("Could not %s product %v: %v", action, p, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(res, req, "/admin/products", http.StatusFound)
+}
@@ -5,12 +5,17 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 )
 
-// HTTPReader implements the Reader interface by registering handlers under
-// /reader/ that can be used to emulate swiping.
+// HTTPReaderName is the name of the HTTPReader, as recorded in transactions.
+const HTTPReaderName = "http"
+
+// HTTPReader emulates a Reader by registering handlers under /reader/ that can
+// be used to emulate swiping. Swipes are handled directly, instead of going
+// through the event loop, so that the result can be shown.
 type HTTPReader struct {
 	k *Kasse
 }
@@ -21,7 +26,8 @@ func RegisterHTTPReader(k *Kasse) (*HTTPReader, error) {
 	r := &HTTPReader{k}
 	router := mux.NewRouter()
 	router.Methods("GET").Path("/reader/").HandlerFunc(r.Index)
-	router.Methods("POST", "GET").Path("/reader/swipe").HandlerFunc(r.Swipe)
+	router.Methods("POST").Path("/reader/swipe").HandlerFunc(r.Swipe)
+	router.Use(k.csrfMiddleware)
 	http.Handle("/reader/", router)
 	return r, nil
 }
@@ -34,17 +40,29 @@ var (
 	</head>
 	<body>
 		<h1>Fake NFC reader für die nnev-Getränkekasse</h1>
-		<form action="swipe" method="GET">
+		<form action="swipe" method="POST">
+			{{ .CSRFField }}
 			<label for="uid">Emuliere swipe von Karte (id in hex)</label>
 			<input type="text" name="uid">
-			<ul>
-			{{ range . }}
-				{{ with printf "%x" .ID }}
-				<li><a href="swipe?uid={{ . }}">{{ . }}</a></li>
-				{{ end }}
+			<label for="product">Produkt</label>
+			<select name="product">
+				<option value="">Standard</option>
+			{{ range .Products }}
+				<option value="{{ .ID }}">{{ .Name }}</option>
 			{{ end }}
-			</ul>
+			</select>
+			<input type="submit" value="Swipe">
 		</form>
+		<ul>
+		{{ range .Cards }}
+			<li>
+				<form action="swipe" method="POST">
+					{{ $.CSRFField }}
+					<button type="submit" name="uid" value="{{ printf "%x" .ID }}">{{ printf "%x" .ID }}</button>
+				</form>
+			</li>
+		{{ end }}
+		</ul>
 	</body>
 </html>`))
 	readerSwipeTpl = template.Must(template.New("swipe").Parse(`<!DOCTYPE html>
@@ -56,6 +74,16 @@ var (
 	<body>
 		<p>{{ . }}</p>
 	</body>
+</html>`))
+	readerPairTpl = template.Must(template.New("pair").Parse(`<!DOCTYPE html>
+<html>
+	<head>
+		<meta charset="UTF-8">
+	</head>
+	<body>
+		<p>Unbekannte Karte. Code zum Koppeln: <strong>{{ . }}</strong></p>
+		<p><a href="/reader/">Zurück</a></p>
+	</body>
 </html>`))
 )
 
@@ -67,7 +95,18 @@ func (r HTTPReader) Index(res http.ResponseWriter, req *http.Request) {
 		log.Println("Could not get cards:", err)
 	}
 
-	if err := readerIndexTpl.Execute(res, cards); err != nil {
+	products, err := r.k.GetProducts()
+	if err != nil {
+		log.Println("Could not get products:", err)
+	}
+
+	data := struct {
+		Cards     []Card
+		Products  []Product
+		CSRFField template.HTML
+	}{cards, products, csrfField(csrfToken(req))}
+
+	if err := readerIndexTpl.Execute(res, data); err != nil {
 		log.Println("Error executing template:", err)
 		panic(err)
 	}
@@ -84,9 +123,30 @@ func (r HTTPReader) Swipe(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	result, err := r.k.HandleCard(uid)
+	var product *Product
+	if id := req.FormValue("product"); id != "" {
+		pid, err := strconv.Atoi(id)
+		if err == nil {
+			product, err = r.k.GetProduct(pid)
+		}
+		if err != nil {
+			res.WriteHeader(400)
+			readerSwipeTpl.Execute(res, "Invalid product")
+			return
+		}
+	}
+
+	result, err := r.k.HandleCard(HTTPReaderName, uid, product)
 	if err == ErrCardNotFound {
 		res.WriteHeader(404)
+		if code, err := r.k.NewPairingCode(uid); err != nil {
+			log.Println("Could not create pairing code:", err)
+		} else {
+			readerPairTpl.Execute(res, code)
+			return
+		}
+	} else if err == ErrCardBlocked {
+		res.WriteHeader(403)
 	} else if err != nil {
 		res.WriteHeader(400)
 	}
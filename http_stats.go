@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+)
+
+const (
+	// statsMonths is the number of months the statistics page covers.
+	statsMonths = 12
+	// statsTopProducts is the number of products shown in the rankings.
+	statsTopProducts = 5
+)
+
+// weekdays are the labels of the weekday chart, starting on Monday.
+var weekdays = []struct {
+	day   time.Weekday
+	label string
+}{
+	{time.Monday, "Mo"}, {time.Tuesday, "Di"}, {time.Wednesday, "Mi"}, {time.Thursday, "Do"},
+	{time.Friday, "Fr"}, {time.Saturday, "Sa"}, {time.Sunday, "So"},
+}
+
+// productsChart renders the number of sales of each product.
+func productsChart(sales []ProductSales) template.HTML {
+	var bars []Bar
+	for _, s := range sales {
+		bars = append(bars, Bar{Label: s.Name, Value: float64(s.Count), Text: fmt.Sprintf("%d× (%.2f €)", s.Count, float64(s.Amount)/100)})
+	}
+	return barChart(bars)
+}
+
+// countBar returns a Bar for a number of swipes, that is only labeled with
+// the number if there are any.
+func countBar(label string, n int) Bar {
+	b := Bar{Label: label, Value: float64(n)}
+	if n > 0 {
+		b.Text = fmt.Sprint(n)
+	}
+	return b
+}
+
+// GetStatsPage renders charts of what the logged in user spent in the last
+// months and which products they bought, and of which products are popular
+// and when cards are swiped in the whole club.
+func (k *Kasse) GetStatsPage(res http.ResponseWriter, req *http.Request) {
+	user, ok := k.sessionUser(req)
+	if !ok {
+		http.Redirect(res, req, "/login.html", 302)
+		return
+	}
+
+	spending, err := k.GetMonthlySpending(user, statsMonths)
+	if err != nil {
+		k.log.Printf("Could not get spending of user %q: %v", user.Name, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	since := spending[0].Month
+
+	own, err := k.GetTopProducts(user.ID, since, statsTopProducts)
+	if err != nil {
+		k.log.Printf("Could not get top products of user %q: %v", user.Name, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	all, err := k.GetTopProducts(0, since, statsTopProducts)
+	if err != nil {
+		k.log.Println("Could not get top products:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	times, err := k.GetSwipeTimes(since)
+	if err != nil {
+		k.log.Println("Could not get swipe times:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	var spendingBars, hourBars, weekdayBars []Bar
+	var total int64
+	for _, s := range spending {
+		spendingBars = append(spendingBars, Bar{Label: s.Month.Format("01/06"), Value: float64(s.Amount), Text: fmt.Sprintf("%.2f", float64(s.Amount)/100)})
+		total += s.Amount
+	}
+	for h, n := range times.Hour {
+		hourBars = append(hourBars, countBar(fmt.Sprint(h), n))
+	}
+	for _, d := range weekdays {
+		weekdayBars = append(weekdayBars, countBar(d.label, times.Weekday[d.day]))
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+
+	data := struct {
+		Months      int
+		Total       float64
+		Spending    template.HTML
+		OwnProducts template.HTML
+		AllProducts template.HTML
+		Hours       template.HTML
+		Weekdays    template.HTML
+	}{
+		Months:      statsMonths,
+		Total:       float64(total) / 100,
+		Spending:    barChart(spendingBars),
+		OwnProducts: productsChart(own),
+		AllProducts: productsChart(all),
+		Hours:       barChart(hourBars),
+		Weekdays:    barChart(weekdayBars),
+	}
+
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Statistik", Body: "stats.html", Data: data, CSRFToken: csrfToken(req)}); err != nil {
+		k.log.Println("Could not render template:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -8,8 +9,12 @@ import (
 	"net/http/cookiejar"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/sessions"
 )
@@ -32,6 +37,25 @@ func createResponse(req *http.Request, res *httptest.ResponseRecorder) *http.Res
 	}
 }
 
+var csrfTokenRe = regexp.MustCompile(`name="csrf_token" value="([^"]*)"`)
+
+// csrfTokenOf returns the CSRF token contained in a form in body, if any.
+func csrfTokenOf(body string) string {
+	if m := csrfTokenRe.FindStringSubmatch(body); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// withCSRF returns a copy of form with the given CSRF token added.
+func withCSRF(form url.Values, token string) url.Values {
+	v := url.Values{csrfFieldName: {token}}
+	for k, vs := range form {
+		v[k] = vs
+	}
+	return v
+}
+
 func TestLogin(t *testing.T) {
 	k := Kasse{db: createDB(t), log: testLogger(t)}
 	k.sessions = sessions.NewCookieStore([]byte("TODO: Set up safer password"))
@@ -75,10 +99,11 @@ func TestLogin(t *testing.T) {
 		{"GET", "http://localhost:9000/", nil, http.StatusOK, map[string]string{"Content-Type": "text/html"}, "<title>ccchd Kasse</title>"},
 	}
 
+	var token string
 	for _, tc := range tests {
 		var body io.Reader
 		if tc.form != nil {
-			body = strings.NewReader(tc.form.Encode())
+			body = strings.NewReader(withCSRF(tc.form, token).Encode())
 		}
 		req, err := http.NewRequest(tc.method, tc.url, body)
 		if err != nil {
@@ -110,6 +135,10 @@ func TestLogin(t *testing.T) {
 			t.Fatalf("%s %s %v: Response does not contain %q\nFull Body:\n%s", tc.method, tc.url, tc.form, tc.grep, rec.Body.String())
 		}
 
+		if tok := csrfTokenOf(rec.Body.String()); tok != "" {
+			token = tok
+		}
+
 		res := createResponse(req, rec)
 		if c := res.Cookies(); len(c) > 0 {
 			t.Logf("Setting cookies %v", res.Cookies())
@@ -154,10 +183,11 @@ func TestNewUser(t *testing.T) {
 		{"POST", "http://localhost:9000/create_user.html", url.Values{"username": []string{"joe"}, "password": []string{"baz"}, "confirm": []string{"bar"}}, http.StatusBadRequest, nil, "Password and confirmation don't match"},
 	}
 
+	var token string
 	for _, tc := range tests {
 		var body io.Reader
 		if tc.form != nil {
-			body = strings.NewReader(tc.form.Encode())
+			body = strings.NewReader(withCSRF(tc.form, token).Encode())
 		}
 		req, err := http.NewRequest(tc.method, tc.url, body)
 		if err != nil {
@@ -189,6 +219,10 @@ func TestNewUser(t *testing.T) {
 			t.Fatalf("%s %s %v: Response does not contain %q\nFull Body:\n%s", tc.method, tc.url, tc.form, tc.grep, rec.Body.String())
 		}
 
+		if tok := csrfTokenOf(rec.Body.String()); tok != "" {
+			token = tok
+		}
+
 		res := createResponse(req, rec)
 		if c := res.Cookies(); len(c) > 0 {
 			t.Logf("Setting cookies %v", res.Cookies())
@@ -196,3 +230,363 @@ func TestNewUser(t *testing.T) {
 		}
 	}
 }
+
+func TestParseEuros(t *testing.T) {
+	tcs := []struct {
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{"5", 500, false},
+		{"2.50", 250, false},
+		{"2,5", 250, false},
+		{" 10,05 ", 1005, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"-5", 0, true},
+		{"+5", 0, true},
+		{"1.234", 0, true},
+		{",50", 0, true},
+		{"foo", 0, true},
+	}
+
+	for _, tc := range tcs {
+		got, err := parseEuros(tc.input)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("parseEuros(%q) == (%v, %v), want (%v, error: %v)", tc.input, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestParseSessionKeys(t *testing.T) {
+	t.Parallel()
+
+	hash := strings.Repeat("ab", 32)
+	block := strings.Repeat("cd", 32)
+	old := strings.Repeat("ef", 64) + " " + strings.Repeat("01", 16)
+
+	tests := []struct {
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, true},
+		{"# only a comment\n", 0, true},
+		{hash, 0, true},
+		{hash + " " + block, 2, false},
+		{"# current\n" + hash + " " + block + "\n\n# old\n" + old + "\n", 4, false},
+		{hash + " " + block + " " + block, 0, true},
+		{"zz " + block, 0, true},
+		{hash[:30] + " " + block, 0, true},
+		{hash + " " + block[:30], 0, true},
+	}
+
+	for _, tc := range tests {
+		keys, err := ParseSessionKeys(strings.NewReader(tc.input))
+		if (err != nil) != tc.wantErr || len(keys) != tc.want {
+			t.Errorf("ParseSessionKeys(%q) == (%d keys, %v), want %d keys and error = %v", tc.input, len(keys), err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestLoadSessionKeys(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	block := strings.Repeat("cd", 32)
+
+	f, err := ioutil.TempFile("", "kasse-session-keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintln(f, hash, block)
+	f.Close()
+
+	if keys, err := LoadSessionKeys(f.Name()); err != nil || len(keys) != 2 {
+		t.Errorf("LoadSessionKeys(file) == (%d keys, %v), want (2 keys, nil)", len(keys), err)
+	}
+
+	os.Setenv(SessionKeysEnv, hash+" "+block+","+hash+" "+block)
+	defer os.Unsetenv(SessionKeysEnv)
+	if keys, err := LoadSessionKeys(""); err != nil || len(keys) != 4 {
+		t.Errorf("LoadSessionKeys from environment == (%d keys, %v), want (4 keys, nil)", len(keys), err)
+	}
+
+	os.Unsetenv(SessionKeysEnv)
+	k1, err := LoadSessionKeys("")
+	if err != nil || len(k1) != 2 {
+		t.Fatalf("LoadSessionKeys without configuration == (%d keys, %v), want (2 random keys, nil)", len(k1), err)
+	}
+	k2, _ := LoadSessionKeys("")
+	if bytes.Equal(k1[0], k2[0]) {
+		t.Errorf("LoadSessionKeys without configuration returns the same key twice")
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	t.Parallel()
+
+	proxies, err := parseTrustedProxies("10.0.0.1, 192.168.0.0/16,::1")
+	if err != nil {
+		t.Fatalf("parseTrustedProxies() == (_, %v), want (_, nil)", err)
+	}
+	if _, err := parseTrustedProxies("10.0.0"); err == nil {
+		t.Errorf("parseTrustedProxies(10.0.0) == (_, nil), want error")
+	}
+	k := Kasse{trustedProxies: proxies}
+
+	tcs := []struct {
+		remote string
+		fwd    string
+		real   string
+		want   string
+	}{
+		{"1.2.3.4:1234", "", "", "1.2.3.4"},
+		// Only trusted proxies may forward addresses.
+		{"1.2.3.4:1234", "5.6.7.8", "5.6.7.8", "1.2.3.4"},
+		{"10.0.0.1:1234", "", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "5.6.7.8", "", "5.6.7.8"},
+		{"10.0.0.1:1234", "", "5.6.7.8", "5.6.7.8"},
+		{"[::1]:1234", "5.6.7.8", "", "5.6.7.8"},
+		// Addresses left of the one added by a trusted proxy can be forged.
+		{"10.0.0.1:1234", "9.9.9.9, 5.6.7.8", "", "5.6.7.8"},
+		{"10.0.0.1:1234", "9.9.9.9, 5.6.7.8, 192.168.1.1", "", "5.6.7.8"},
+	}
+	for _, tc := range tcs {
+		req := httptest.NewRequest("POST", "/login.html", nil)
+		req.RemoteAddr = tc.remote
+		if tc.fwd != "" {
+			req.Header.Set("X-Forwarded-For", tc.fwd)
+		}
+		if tc.real != "" {
+			req.Header.Set("X-Real-IP", tc.real)
+		}
+		if got := k.remoteIP(req); got != tc.want {
+			t.Errorf("remoteIP() from %s with X-Forwarded-For %q and X-Real-IP %q == %q, want %q", tc.remote, tc.fwd, tc.real, got, tc.want)
+		}
+	}
+}
+
+func TestSessionConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	tcs := []struct {
+		secure   bool
+		sameSite http.SameSite
+		wantErr  bool
+	}{
+		{false, http.SameSiteLaxMode, false},
+		{false, http.SameSiteStrictMode, false},
+		{false, http.SameSiteNoneMode, true},
+		{true, http.SameSiteNoneMode, false},
+	}
+	for _, tc := range tcs {
+		err := SessionConfig{Secure: tc.secure, SameSite: tc.sameSite}.Validate()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("Validate() with Secure %v and SameSite %v == %v, want error: %v", tc.secure, tc.sameSite, err, tc.wantErr)
+		}
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	hash := bytes.Repeat([]byte{1}, 32)
+	block := bytes.Repeat([]byte{2}, 32)
+	k.sessions = NewSessionStore(SessionConfig{
+		Keys:     [][]byte{hash, block},
+		MaxAge:   time.Hour,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	h := k.Handler()
+
+	if _, err := k.RegisterUser("Merovius", []byte("foobar")); err != nil {
+		t.Fatalf("RegisterUser(Merovius) == (_, %v), want (_, nil)", err)
+	}
+
+	login := func(h http.Handler) *http.Cookie {
+		req := httptest.NewRequest("GET", "https://localhost:9000/login.html", nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		token := csrfTokenOf(rec.Body.String())
+		cookies := createResponse(req, rec).Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("Login page sets %d cookies, want 1", len(cookies))
+		}
+
+		form := url.Values{"username": {"Merovius"}, "password": {"foobar"}, csrfFieldName: {token}}
+		req = httptest.NewRequest("POST", "https://localhost:9000/login.html", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookies[0])
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		cookies = createResponse(req, rec).Cookies()
+		if len(cookies) != 1 {
+			t.Fatalf("Login sets %d cookies, want 1", len(cookies))
+		}
+		return cookies[0]
+	}
+	dashboard := func(h http.Handler, c *http.Cookie) int {
+		req := httptest.NewRequest("GET", "https://localhost:9000/", nil)
+		req.AddCookie(c)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	c := login(h)
+	if !c.HttpOnly || !c.Secure || c.SameSite != http.SameSiteStrictMode || c.MaxAge != 3600 {
+		t.Errorf("Session cookie == %v, want HttpOnly, Secure, SameSite=Strict and Max-Age=3600", c)
+	}
+	if strings.Contains(c.Value, "Merovius") {
+		t.Errorf("Session cookie %q is not encrypted", c.Value)
+	}
+	if code := dashboard(h, c); code != http.StatusOK {
+		t.Errorf("GET / with session cookie has code %d, expected %d", code, http.StatusOK)
+	}
+
+	// After rotating the keys, old cookies are still accepted, but cookies of
+	// unknown keys are not.
+	newHash := bytes.Repeat([]byte{3}, 32)
+	newBlock := bytes.Repeat([]byte{4}, 32)
+	k.sessions = NewSessionStore(SessionConfig{Keys: [][]byte{newHash, newBlock, hash, block}, MaxAge: time.Hour})
+	if code := dashboard(k.Handler(), c); code != http.StatusOK {
+		t.Errorf("GET / with cookie of rotated key has code %d, expected %d", code, http.StatusOK)
+	}
+	k.sessions = NewSessionStore(SessionConfig{Keys: [][]byte{newHash, newBlock}, MaxAge: time.Hour})
+	if code := dashboard(k.Handler(), c); code != http.StatusFound {
+		t.Errorf("GET / with cookie of removed key has code %d, expected %d", code, http.StatusFound)
+	}
+}
+
+func TestCSRF(t *testing.T) {
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	k.sessions = sessions.NewCookieStore([]byte("foobar"))
+	h := k.Handler()
+
+	if _, err := k.RegisterUser("Merovius", []byte("foobar")); err != nil {
+		t.Fatalf("RegisterUser(Merovius) == (_, %v), want (_, nil)", err)
+	}
+
+	req := httptest.NewRequest("GET", "http://localhost:9000/login.html", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	token := csrfTokenOf(rec.Body.String())
+	if token == "" {
+		t.Fatalf("Login page contains no CSRF token\nFull Body:\n%s", rec.Body.String())
+	}
+	cookies := createResponse(req, rec).Cookies()
+
+	login := url.Values{"username": {"Merovius"}, "password": {"foobar"}}
+	tests := []struct {
+		form   url.Values
+		header string
+		cookie bool
+		code   int
+	}{
+		{login, "", true, http.StatusForbidden},
+		{withCSRF(login, "wrong"), "", true, http.StatusForbidden},
+		{withCSRF(login, token), "", false, http.StatusForbidden},
+		{withCSRF(login, token), "", true, http.StatusFound},
+		{login, token, true, http.StatusFound},
+	}
+
+	for _, tc := range tests {
+		req := httptest.NewRequest("POST", "http://localhost:9000/login.html", strings.NewReader(tc.form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tc.header != "" {
+			req.Header.Set(csrfHeader, tc.header)
+		}
+		if tc.cookie {
+			for _, c := range cookies {
+				req.AddCookie(c)
+			}
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.code {
+			t.Errorf("POST /login.html %v with header %q, cookie = %v has code %d, expected %d", tc.form, tc.header, tc.cookie, rec.Code, tc.code)
+		}
+		if rec.Code == http.StatusForbidden && !strings.Contains(rec.Body.String(), "<title>Fehler</title>") {
+			t.Errorf("POST /login.html %v: Response is no error page\nFull Body:\n%s", tc.form, rec.Body.String())
+		}
+	}
+}
+
+func TestAdminPage(t *testing.T) {
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	k.sessions = sessions.NewCookieStore([]byte("foobar"))
+	h := k.Handler()
+
+	for _, name := range []string{"Merovius", "koebi"} {
+		if _, err := k.RegisterUser(name, []byte("foobar")); err != nil {
+			t.Fatalf("RegisterUser(%s) == (_, %v), want (_, nil)", name, err)
+		}
+	}
+
+	jar, _ := cookiejar.New(nil)
+	do := func(method, target string, form url.Values) *httptest.ResponseRecorder {
+		var body io.Reader
+		if form != nil {
+			body = strings.NewReader(form.Encode())
+		}
+		req := httptest.NewRequest(method, target, body)
+		if form != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for _, c := range jar.Cookies(req.URL) {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		jar.SetCookies(req.URL, createResponse(req, rec).Cookies())
+		return rec
+	}
+
+	if rec := do("GET", "http://localhost:9000/admin/", nil); rec.Code != http.StatusFound || rec.HeaderMap.Get("Location") != "/login.html" {
+		t.Errorf("GET /admin/ without login has code %d and location %q, expected %d and /login.html", rec.Code, rec.HeaderMap.Get("Location"), http.StatusFound)
+	}
+
+	token := csrfTokenOf(do("GET", "http://localhost:9000/login.html", nil).Body.String())
+	if rec := do("POST", "http://localhost:9000/login.html", withCSRF(url.Values{"username": {"Merovius"}, "password": {"foobar"}}, token)); rec.Code != http.StatusFound {
+		t.Fatalf("POST /login.html has code %d, expected %d", rec.Code, http.StatusFound)
+	}
+
+	for _, path := range []string{"/admin/", "/admin/topups", "/admin/products", "/admin/limits", "/admin/lockouts", "/admin/transactions.csv"} {
+		if rec := do("GET", "http://localhost:9000"+path, nil); rec.Code != http.StatusForbidden {
+			t.Errorf("GET %s as non-treasurer has code %d, expected %d", path, rec.Code, http.StatusForbidden)
+		}
+	}
+
+	if err := k.PromoteTreasurer("Merovius"); err != nil {
+		t.Fatalf("PromoteTreasurer(Merovius) = %v", err)
+	}
+	if rec := do("GET", "http://localhost:9000/admin", nil); rec.Code != http.StatusFound || rec.HeaderMap.Get("Location") != "/admin/" {
+		t.Errorf("GET /admin has code %d and location %q, expected %d and /admin/", rec.Code, rec.HeaderMap.Get("Location"), http.StatusFound)
+	}
+	rec := do("GET", "http://localhost:9000/admin/", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /admin/ as treasurer has code %d, expected %d", rec.Code, http.StatusOK)
+	}
+	for _, want := range []string{"<title>Verwaltung</title>", "koebi", "Merovius (Kassenwart)"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("GET /admin/: Response does not contain %q\nFull Body:\n%s", want, rec.Body.String())
+		}
+	}
+	if rec := do("GET", "http://localhost:9000/admin/topups", nil); !strings.Contains(rec.Body.String(), "einzige Kassenwart") {
+		t.Errorf("GET /admin/topups as only treasurer has code %d and does not warn about own top-ups\nFull Body:\n%s", rec.Code, rec.Body.String())
+	}
+
+	mate, err := k.AddProduct(User{}, "Mate", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token = csrfTokenOf(do("GET", "http://localhost:9000/admin/inventory", nil).Body.String())
+	form := url.Values{"id": {strconv.Itoa(mate.ID)}, "action": {"reorder"}, "quantity": {"12"}}
+	if rec := do("POST", "http://localhost:9000/admin/inventory", withCSRF(form, token)); rec.Code != http.StatusFound {
+		t.Fatalf("POST /admin/inventory has code %d, expected %d", rec.Code, http.StatusFound)
+	}
+	rec = do("GET", "http://localhost:9000/admin/", nil)
+	if want := "Nachbestellen"; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("GET /admin/ with low stock: Response does not contain %q\nFull Body:\n%s", want, rec.Body.String())
+	}
+}
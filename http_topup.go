@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// PostTopUp receives a POST request with an amount in euros and files a
+// top-up request for the logged in user. It redirects to the dashboard on
+// success.
+func (k *Kasse) PostTopUp(res http.ResponseWriter, req *http.Request) {
+	user, ok := k.sessionUser(req)
+	if !ok {
+		http.Redirect(res, req, "/login.html", 302)
+		return
+	}
+
+	amount, err := parseEuros(req.FormValue("amount"))
+	if err != nil || amount <= 0 {
+		// TODO: Write own Error function, that uses a template for better
+		// looking error pages. Also, redirect.
+		http.Error(res, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := k.RequestTopUp(user, amount); err != nil {
+		k.log.Printf("Could not request top-up for user %q: %v", user.Name, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(res, req, "/", http.StatusFound)
+}
+
+// GetTopUpsPage renders the queue of pending top-up requests. If there is only
+// one treasurer, it says that their own top-ups can't be approved.
+func (k *Kasse) GetTopUpsPage(res http.ResponseWriter, req *http.Request) {
+	topups, err := k.GetPendingTopUps()
+	if err != nil {
+		k.log.Println("Could not get pending top-ups:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	treasurers, err := k.CountTreasurers()
+	if err != nil {
+		k.log.Println("Could not count treasurers:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+
+	data := struct {
+		TopUps        []PendingTopUp
+		OnlyTreasurer bool
+	}{topups, treasurers < 2}
+
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Aufladungen", Body: "topups.html", Data: data, CSRFToken: csrfToken(req)}); err != nil {
+		k.log.Println("Could not render template:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PostTopUpsPage receives a POST request with the id of a pending top-up
+// request and an action, which is either "approve" or "reject". It redirects
+// back to the queue on success.
+func (k *Kasse) PostTopUpsPage(res http.ResponseWriter, req *http.Request) {
+	treasurer := contextTreasurer(req)
+	id, err := strconv.Atoi(req.FormValue("id"))
+	if err != nil {
+		http.Error(res, "Invalid top-up id", http.StatusBadRequest)
+		return
+	}
+
+	switch req.FormValue("action") {
+	case "approve":
+		err = k.ApproveTopUp(id, treasurer)
+	case "reject":
+		err = k.RejectTopUp(id, treasurer)
+	default:
+		http.Error(res, "Invalid action", http.StatusBadRequest)
+		return
+	}
+
+	switch err {
+	case nil:
+	case ErrNotTreasurer:
+		http.Error(res, "Only treasurers can approve top-ups", http.StatusForbidden)
+		return
+	case ErrTopUpNotFound:
+		http.Error(res, "No such pending top-up", http.StatusNotFound)
+		return
+	case ErrOwnTopUp:
+		http.Error(res, "Your own top-ups have to be approved by another treasurer", http.StatusForbidden)
+		return
+	default:
+		k.log.Printf("Could not decide top-up %d: %v", id, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(res, req, "/admin/topups", http.StatusFound)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// transactionsPageSize is the number of transactions shown per page of the
+// transaction history.
+const transactionsPageSize = 20
+
+// parseTransactionQuery parses the filters of the transaction history from the
+// form values of req. Dates are given as YYYY-MM-DD, until is inclusive.
+func parseTransactionQuery(req *http.Request, user User) (TransactionQuery, error) {
+	q := TransactionQuery{User: user.ID}
+
+	var err error
+	if s := req.FormValue("from"); s != "" {
+		if q.From, err = time.ParseInLocation("2006-01-02", s, time.Local); err != nil {
+			return q, err
+		}
+	}
+	if s := req.FormValue("until"); s != "" {
+		if q.Until, err = time.ParseInLocation("2006-01-02", s, time.Local); err != nil {
+			return q, err
+		}
+		q.Until = q.Until.AddDate(0, 0, 1)
+	}
+	q.Kind = req.FormValue("kind")
+	if s := req.FormValue("card"); s != "" {
+		if q.Card, err = parseUID(s); err != nil {
+			return q, err
+		}
+	}
+	if s := req.FormValue("before"); s != "" {
+		if q.Before, err = strconv.Atoi(s); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// GetTransactionsPage renders the transaction history of the logged in user,
+// optionally filtered by date, kind and card. It shows transactionsPageSize
+// transactions at a time, older ones are reached by the cursor "before".
+func (k *Kasse) GetTransactionsPage(res http.ResponseWriter, req *http.Request) {
+	user, ok := k.sessionUser(req)
+	if !ok {
+		http.Redirect(res, req, "/login.html", 302)
+		return
+	}
+
+	q, err := parseTransactionQuery(req, user)
+	if err != nil {
+		http.Error(res, "Invalid filter", http.StatusBadRequest)
+		return
+	}
+	q.Limit = transactionsPageSize + 1
+
+	transactions, err := k.QueryTransactions(q)
+	if err != nil {
+		k.log.Printf("Could not get transactions for user %q: %v", user.Name, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	// We fetched one more transaction than we show, to know whether there is
+	// another page.
+	var next string
+	if len(transactions) > transactionsPageSize {
+		transactions = transactions[:transactionsPageSize]
+		v := url.Values{}
+		for _, f := range []string{"from", "until", "kind", "card"} {
+			if s := req.FormValue(f); s != "" {
+				v.Set(f, s)
+			}
+		}
+		v.Set("before", strconv.Itoa(transactions[len(transactions)-1].ID))
+		next = "/transactions.html?" + v.Encode()
+	}
+
+	kinds, err := k.GetTransactionKinds(user)
+	if err != nil {
+		k.log.Printf("Could not get transaction kinds for user %q: %v", user.Name, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	cards, err := k.GetCards(user)
+	if err != nil {
+		k.log.Printf("Could not get cards for user %q: %v", user.Name, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/html")
+
+	data := struct {
+		Transactions []Transaction
+		Kinds        []string
+		Cards        []Card
+		From         string
+		Until        string
+		Kind         string
+		Card         string
+		Next         string
+	}{
+		Transactions: transactions,
+		Kinds:        kinds,
+		Cards:        cards,
+		From:         req.FormValue("from"),
+		Until:        req.FormValue("until"),
+		Kind:         q.Kind,
+		Card:         req.FormValue("card"),
+		Next:         next,
+	}
+
+	if err := ExecuteTemplate(res, TemplateInput{Title: "Transaktionen", Body: "transactions.html", Data: data, CSRFToken: csrfToken(req)}); err != nil {
+		k.log.Println("Could not render template:", err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+}
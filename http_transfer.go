@@ -0,0 +1,39 @@
+package main
+
+import "net/http"
+
+// PostTransfer receives a POST request with the name of a recipient and an
+// amount in euros and transfers that amount from the logged in user to the
+// recipient. It redirects to the dashboard on success.
+func (k *Kasse) PostTransfer(res http.ResponseWriter, req *http.Request) {
+	user, ok := k.sessionUser(req)
+	if !ok {
+		http.Redirect(res, req, "/login.html", 302)
+		return
+	}
+
+	amount, err := parseEuros(req.FormValue("amount"))
+	if err != nil || amount <= 0 {
+		http.Error(res, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+
+	switch _, err := k.Transfer(user, req.FormValue("to"), amount); err {
+	case nil:
+	case ErrUserNotFound:
+		http.Error(res, "No such user", http.StatusNotFound)
+		return
+	case ErrTransferToSelf:
+		http.Error(res, "Can't transfer money to yourself", http.StatusBadRequest)
+		return
+	case ErrAccountEmpty:
+		http.Error(res, "Insufficient funds", http.StatusPaymentRequired)
+		return
+	default:
+		k.log.Printf("Could not transfer money from user %q: %v", user.Name, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(res, req, "/", http.StatusFound)
+}
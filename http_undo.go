@@ -0,0 +1,24 @@
+package main
+
+import "net/http"
+
+// PostUndo receives a POST request to undo the last swipe of the logged in
+// user. It redirects to the dashboard on success.
+func (k *Kasse) PostUndo(res http.ResponseWriter, req *http.Request) {
+	user, ok := k.sessionUser(req)
+	if !ok {
+		http.Redirect(res, req, "/login.html", 302)
+		return
+	}
+
+	if err := k.UndoLastSwipe(user); err == ErrNothingToUndo {
+		http.Error(res, "Nothing to undo", http.StatusConflict)
+		return
+	} else if err != nil {
+		k.log.Printf("Could not undo last swipe of user %q: %v", user.Name, err)
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(res, req, "/", http.StatusFound)
+}
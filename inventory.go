@@ -0,0 +1,205 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrInvalidQuantity means that a delivery of no units or a negative count of
+// units was tried to record.
+var ErrInvalidQuantity = errors.New("invalid quantity")
+
+// StockChange is a delivery or stock-take of a product (as in the database
+// schema).
+type StockChange struct {
+	ID          int           `db:"change_id"`
+	Product     int           `db:"product_id"`
+	ProductName string        `db:"product_name"`
+	User        sql.NullInt64 `db:"user_id"`
+	Time        time.Time     `db:"time"`
+	// Kind is either "delivery" or "stocktake".
+	Kind string `db:"kind"`
+	// Quantity is the number of units delivered or counted.
+	Quantity int `db:"quantity"`
+	// Expected is the stock of the product before the change.
+	Expected int `db:"expected"`
+}
+
+// Shrinkage returns the number of units missing at a stock-take, i.e. how
+// many more were expected than counted. It is negative if more units were
+// counted and zero for deliveries.
+func (c StockChange) Shrinkage() int {
+	if c.Kind != "stocktake" {
+		return 0
+	}
+	return c.Expected - c.Quantity
+}
+
+// LowStock returns whether p is below its reorder level.
+func (p Product) LowStock() bool {
+	return p.ReorderLevel.Valid && int64(p.Stock) < p.ReorderLevel.Int64
+}
+
+// adjustStock adds n to the stock of the product with the given id. It is
+// called with -1 for every sale and with 1 when a sale is undone.
+func adjustStock(tx execer, product int, n int) error {
+	_, err := tx.Exec(`UPDATE products SET stock = stock + $1 WHERE product_id = $2`, n, product)
+	return err
+}
+
+// RecordDelivery adds quantity units to the stock of the product with the
+// given id on behalf of actor. It returns ErrProductNotFound if there is no
+// such product.
+func (k *Kasse) RecordDelivery(actor User, product, quantity int) (*StockChange, error) {
+	k.log.Printf("Recording delivery of %d units of product %d", quantity, product)
+
+	if quantity <= 0 {
+		return nil, ErrInvalidQuantity
+	}
+	return k.recordStockChange(actor, product, "delivery", quantity)
+}
+
+// RecordStockTake sets the stock of the product with the given id to the
+// counted number of units on behalf of actor. The difference to the expected
+// stock is recorded as shrinkage. It returns ErrProductNotFound if there is no
+// such product.
+func (k *Kasse) RecordStockTake(actor User, product, counted int) (*StockChange, error) {
+	k.log.Printf("Recording stock-take of %d units of product %d", counted, product)
+
+	if counted < 0 {
+		return nil, ErrInvalidQuantity
+	}
+	return k.recordStockChange(actor, product, "stocktake", counted)
+}
+
+// lockProduct gets the product with the given id in tx, like getProduct, and
+// locks it until tx ends, so that its stock can't change in between. SQLite
+// has no row locks, but it fails write transactions that read a stale stock.
+func lockProduct(tx *sqlx.Tx, id int) (*Product, error) {
+	query := `SELECT product_id, name, price, is_default, stock, reorder_level FROM products WHERE product_id = $1`
+	if tx.DriverName() == "postgres" {
+		query += ` FOR UPDATE`
+	}
+	p := new(Product)
+	if err := tx.Get(p, query, id); err == sql.ErrNoRows {
+		return nil, ErrProductNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// recordStockChange records a delivery or stock-take and updates the stock
+// accordingly.
+func (k *Kasse) recordStockChange(actor User, product int, kind string, quantity int) (*StockChange, error) {
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	p, err := lockProduct(tx, product)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &StockChange{
+		Product:     p.ID,
+		ProductName: p.Name,
+		Time:        time.Now(),
+		Kind:        kind,
+		Quantity:    quantity,
+		Expected:    p.Stock,
+	}
+	if actor.ID != 0 {
+		c.User = sql.NullInt64{Int64: int64(actor.ID), Valid: true}
+	}
+
+	if err := tx.Get(&c.ID, `INSERT INTO stock_changes (product_id, user_id, time, kind, quantity, expected) VALUES ($1, $2, $3, $4, $5, $6) RETURNING change_id`, c.Product, c.User, c.Time, c.Kind, c.Quantity, c.Expected); err != nil {
+		return nil, err
+	}
+	stock := quantity
+	if kind == "delivery" {
+		stock += p.Stock
+		err = adjustStock(tx, p.ID, quantity)
+	} else {
+		_, err = tx.Exec(`UPDATE products SET stock = $1 WHERE product_id = $2`, stock, p.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	before := map[string]interface{}{"stock": p.Stock}
+	after := map[string]interface{}{"stock": stock, "quantity": quantity}
+	if err := k.audit(tx, actor, "stock."+kind, fmt.Sprintf("product %d", p.ID), before, after); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SetReorderLevel sets the stock below which the product with the given id is
+// flagged for reordering, on behalf of actor. If level is not valid, it is
+// never flagged. It returns ErrProductNotFound if there is no such product.
+func (k *Kasse) SetReorderLevel(actor User, product int, level sql.NullInt64) error {
+	k.log.Printf("Setting reorder level of product %d to %v", product, level)
+
+	if level.Valid && level.Int64 < 0 {
+		return ErrInvalidQuantity
+	}
+
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	p, err := getProduct(tx, product)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE products SET reorder_level = $1 WHERE product_id = $2`, level, p.ID); err != nil {
+		return err
+	}
+	before := map[string]interface{}{"reorder_level": nil}
+	if p.ReorderLevel.Valid {
+		before["reorder_level"] = p.ReorderLevel.Int64
+	}
+	after := map[string]interface{}{"reorder_level": nil}
+	if level.Valid {
+		after["reorder_level"] = level.Int64
+	}
+	if err := k.audit(tx, actor, "product.reorder_level", fmt.Sprintf("product %d", p.ID), before, after); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetLowStockProducts gets all products below their reorder level, ordered by
+// name.
+func (k *Kasse) GetLowStockProducts() ([]Product, error) {
+	var products []Product
+	if err := k.db.Select(&products, `SELECT product_id, name, price, is_default, stock, reorder_level FROM products WHERE reorder_level IS NOT NULL AND stock < reorder_level ORDER BY name`); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// GetStockChanges gets the newest deliveries and stock-takes of all products,
+// at most limit of them.
+func (k *Kasse) GetStockChanges(limit int) ([]StockChange, error) {
+	var changes []StockChange
+	if err := k.db.Select(&changes, `SELECT c.change_id, c.product_id, p.name AS product_name, c.user_id, c.time, c.kind, c.quantity, c.expected
+		FROM stock_changes c JOIN products p ON c.product_id = p.product_id
+		ORDER BY c.change_id DESC LIMIT $1`, limit); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestInventory(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	defer k.db.Close()
+
+	insertData(t, k.db, []User{{ID: 1, Name: "Merovius"}}, []Card{{ID: []byte("aaaa"), User: 1}}, []Transaction{
+		{ID: 1, User: 1, Time: time.Now(), Amount: 1000, Kind: "Aufladung"},
+	})
+	mate, err := k.AddProduct(User{}, "Mate", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	beer, err := k.AddProduct(User{}, "Bier", 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetDefaultProduct(User{}, mate.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	stock := func(want int) {
+		t.Helper()
+		if p, err := k.GetProduct(mate.ID); err != nil || p.Stock != want {
+			t.Errorf("stock of Mate is (%v, %v), want %d", p, err, want)
+		}
+	}
+
+	if _, err := k.RecordDelivery(User{}, mate.ID, 0); err != ErrInvalidQuantity {
+		t.Errorf("RecordDelivery(Mate, 0) == (_, %v), want (_, %v)", err, ErrInvalidQuantity)
+	}
+	if _, err := k.RecordDelivery(User{}, 23, 10); err != ErrProductNotFound {
+		t.Errorf("RecordDelivery(23, 10) == (_, %v), want (_, %v)", err, ErrProductNotFound)
+	}
+	if _, err := k.RecordDelivery(User{}, mate.ID, 24); err != nil {
+		t.Fatalf("RecordDelivery(Mate, 24) == (_, %v), want (_, nil)", err)
+	}
+	stock(24)
+
+	if _, err := k.HandleCard("front", []byte("aaaa"), nil); err != nil {
+		t.Fatalf("HandleCard(aaaa) == (_, %v), want (_, nil)", err)
+	}
+	stock(23)
+	if _, err := k.CancelSwipe("front", []byte("aaaa")); err != nil {
+		t.Fatalf("CancelSwipe(aaaa) == (_, %v), want (_, nil)", err)
+	}
+	stock(24)
+	if _, err := k.HandleCard("front", []byte("aaaa"), nil); err != nil {
+		t.Fatalf("HandleCard(aaaa) == (_, %v), want (_, nil)", err)
+	}
+	stock(23)
+
+	if _, err := k.RecordStockTake(User{}, mate.ID, -1); err != ErrInvalidQuantity {
+		t.Errorf("RecordStockTake(Mate, -1) == (_, %v), want (_, %v)", err, ErrInvalidQuantity)
+	}
+	c, err := k.RecordStockTake(User{}, mate.ID, 20)
+	if err != nil {
+		t.Fatalf("RecordStockTake(Mate, 20) == (_, %v), want (_, nil)", err)
+	}
+	if c.Expected != 23 || c.Shrinkage() != 3 {
+		t.Errorf("RecordStockTake(Mate, 20) expected %d and has shrinkage %d, want 23 and 3", c.Expected, c.Shrinkage())
+	}
+	stock(20)
+
+	if ps, err := k.GetLowStockProducts(); err != nil || len(ps) != 0 {
+		t.Errorf("GetLowStockProducts() without reorder levels == (%v, %v), want none", ps, err)
+	}
+	if err := k.SetReorderLevel(User{}, mate.ID, sql.NullInt64{Int64: 20, Valid: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetReorderLevel(User{}, beer.ID, sql.NullInt64{Int64: 1, Valid: true}); err != nil {
+		t.Fatal(err)
+	}
+	if ps, err := k.GetLowStockProducts(); err != nil || len(ps) != 1 || ps[0].ID != beer.ID {
+		t.Errorf("GetLowStockProducts() == (%v, %v), want only Bier", ps, err)
+	}
+	if _, err := k.HandleCard("front", []byte("aaaa"), nil); err != nil {
+		t.Fatalf("HandleCard(aaaa) == (_, %v), want (_, nil)", err)
+	}
+	if ps, err := k.GetLowStockProducts(); err != nil || len(ps) != 2 || !ps[1].LowStock() {
+		t.Errorf("GetLowStockProducts() after sale == (%v, %v), want Bier and Mate", ps, err)
+	}
+
+	changes, err := k.GetStockChanges(10)
+	if err != nil {
+		t.Fatalf("GetStockChanges() == (_, %v), want (_, nil)", err)
+	}
+	if len(changes) != 2 || changes[0].Kind != "stocktake" || changes[1].Kind != "delivery" || changes[1].Shrinkage() != 0 {
+		t.Errorf("GetStockChanges() == %+v, want stock-take and delivery of Mate", changes)
+	}
+
+	if _, err := k.RecordDelivery(User{}, beer.ID, 6); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.DeleteProduct(User{}, beer.ID); err != ErrProductInUse {
+		t.Errorf("DeleteProduct(Bier) after delivery == %v, want %v", err, ErrProductInUse)
+	}
+}
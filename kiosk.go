@@ -0,0 +1,60 @@
+package main
+
+import "sync"
+
+// KioskEvent is a Result, as it is sent to the kiosk display.
+type KioskEvent struct {
+	Code    string  `json:"code"`
+	Reader  string  `json:"reader"`
+	User    string  `json:"user"`
+	Product string  `json:"product"`
+	Balance float32 `json:"balance"`
+}
+
+// Kiosk distributes the Results of the main event loop to all connected kiosk
+// displays. The zero value is ready to use.
+type Kiosk struct {
+	mu   sync.Mutex
+	subs map[chan KioskEvent]bool
+}
+
+// Publish sends res to all subscribed kiosk displays. It never blocks; if a
+// display can't keep up, the event is dropped for it.
+func (ks *Kiosk) Publish(res *Result) {
+	ev := KioskEvent{
+		Code:    res.Code.String(),
+		Reader:  res.Reader,
+		User:    res.User,
+		Product: res.Product,
+		Balance: res.Account,
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	for ch := range ks.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel on which all published events are sent, until
+// it is passed to unsubscribe.
+func (ks *Kiosk) subscribe() chan KioskEvent {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if ks.subs == nil {
+		ks.subs = make(map[chan KioskEvent]bool)
+	}
+	ch := make(chan KioskEvent, 8)
+	ks.subs[ch] = true
+	return ch
+}
+
+// unsubscribe stops sending events to ch.
+func (ks *Kiosk) unsubscribe(ch chan KioskEvent) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	delete(ks.subs, ch)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/sessions"
+)
+
+func TestKioskEvents(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	k.sessions = sessions.NewCookieStore([]byte("foobar"))
+	srv := httptest.NewServer(k.Handler())
+	defer srv.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	for _, tc := range []struct {
+		token  string
+		target string
+		want   int
+	}{
+		{"", "/kiosk/events", http.StatusNotFound},
+		{"secret", "/kiosk/events", http.StatusForbidden},
+		{"secret", "/kiosk/events?token=wrong", http.StatusForbidden},
+		{"secret", "/kiosk/stock?token=secret", http.StatusOK},
+		{"secret", "/kiosk?token=secret", http.StatusFound},
+	} {
+		k.kioskToken = tc.token
+		resp, err := client.Get(srv.URL + tc.target)
+		if err != nil {
+			t.Fatalf("GET %s == %v, want nil", tc.target, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("GET %s with kiosk token %q has code %d, want %d", tc.target, tc.token, resp.StatusCode, tc.want)
+		}
+	}
+
+	// The kiosk page stores the token in a cookie and redirects to itself, so
+	// the events are requested without the token in the URL.
+	resp, err := client.Get(srv.URL + "/kiosk?token=secret")
+	if err != nil {
+		t.Fatalf("GET /kiosk == %v, want nil", err)
+	}
+	resp.Body.Close()
+	if loc := resp.Header.Get("Location"); loc != "/kiosk" {
+		t.Errorf("GET /kiosk?token=secret redirects to %q, want %q", loc, "/kiosk")
+	}
+	var cookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == kioskCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatalf("GET /kiosk?token=secret sets no kiosk cookie")
+	}
+
+	req, err := http.NewRequest("GET", srv.URL+"/kiosk/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(cookie)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("GET /kiosk/events == %v, want nil", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /kiosk/events with kiosk cookie has code %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type of /kiosk/events == %q, want %q", ct, "text/event-stream")
+	}
+
+	// The subscription is made before the headers are sent, so the event
+	// can't get lost.
+	k.kiosk.Publish(&Result{Code: LowBalance, Reader: "front", User: "Merovius", Account: 1.5})
+
+	s := bufio.NewScanner(resp.Body)
+	var line string
+	for s.Scan() {
+		if line = s.Text(); line != "" {
+			break
+		}
+	}
+	if !strings.HasPrefix(line, "data: ") {
+		t.Fatalf("First event line == %q, want data", line)
+	}
+	var got KioskEvent
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &got); err != nil {
+		t.Fatalf("Could not unmarshal event %q: %v", line, err)
+	}
+	want := KioskEvent{Code: "LowBalance", Reader: "front", User: "Merovius", Balance: 1.5}
+	if got != want {
+		t.Errorf("Event == %+v, want %+v", got, want)
+	}
+}
+
+func TestRedactTokens(t *testing.T) {
+	t.Parallel()
+
+	var gotToken string
+	h := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		gotToken = req.URL.Query().Get("token")
+	})
+	var log bytes.Buffer
+	req := httptest.NewRequest("GET", "/kiosk?token=secret", nil)
+	redactTokens(handlers.LoggingHandler(&log, h)).ServeHTTP(httptest.NewRecorder(), req)
+
+	if gotToken != "secret" {
+		t.Errorf("Handler got token %q, want %q", gotToken, "secret")
+	}
+	if strings.Contains(log.String(), "secret") {
+		t.Errorf("Access log %q contains the token", log.String())
+	}
+	if !strings.Contains(log.String(), "/kiosk?token=REDACTED") {
+		t.Errorf("Access log %q does not contain the redacted URI", log.String())
+	}
+}
+
+func TestKioskPublish(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t), cancelWindow: time.Minute}
+	defer k.db.Close()
+
+	insertData(t, k.db, []User{{ID: 1, Name: "Merovius", Password: []byte("password")}}, []Card{{ID: []byte("aaaa"), User: 1}}, []Transaction{
+		{ID: 1, User: 1, Time: time.Now(), Amount: 1000, Kind: "Aufladung"},
+	})
+
+	ch := k.kiosk.subscribe()
+	defer k.kiosk.unsubscribe(ch)
+
+	// Swipes at the HTTP reader and through the API call HandleCard and
+	// CancelSwipe directly, not the main event loop.
+	if _, err := k.HandleCard(APIReaderName, []byte("aaaa"), nil); err != nil {
+		t.Fatalf("HandleCard(aaaa) == %v, want nil", err)
+	}
+	if _, err := k.CancelSwipe(APIReaderName, []byte("aaaa")); err != nil {
+		t.Fatalf("CancelSwipe(aaaa) == %v, want nil", err)
+	}
+
+	for _, want := range []KioskEvent{
+		{Code: "PaymentMade", Reader: APIReaderName, User: "Merovius", Product: "Getränk", Balance: 9},
+		{Code: "SwipeUndone", Reader: APIReaderName, User: "Merovius", Product: "Getränk", Balance: 10},
+	} {
+		select {
+		case got := <-ch:
+			if got != want {
+				t.Errorf("Event == %+v, want %+v", got, want)
+			}
+		default:
+			t.Fatalf("No event published, want %+v", want)
+		}
+	}
+}
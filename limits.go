@@ -0,0 +1,132 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Limits configures which balances are acceptable after a charge.
+type Limits struct {
+	// LowBalance is the amount (in cents) of remaining funds, below which a
+	// swipe is answered with LowBalance.
+	LowBalance int
+	// Overdraft is the amount (in cents) a user may go below zero, if no
+	// personal limit is set for them.
+	Overdraft int
+}
+
+// DefaultLimits are used, if no Limits are configured for a Kasse.
+var DefaultLimits = Limits{
+	LowBalance: 500,
+	Overdraft:  0,
+}
+
+// ErrUserNotFound means that there is no user with the given id or name.
+var ErrUserNotFound = errors.New("user not found")
+
+// getLimits returns the Limits configured for k.
+func (k *Kasse) getLimits() Limits {
+	if k.limits == nil {
+		return DefaultLimits
+	}
+	return *k.limits
+}
+
+// overdraft returns the amount (in cents) user may go below zero.
+func (k *Kasse) overdraft(user User) int {
+	if user.Overdraft.Valid {
+		return int(user.Overdraft.Int64)
+	}
+	return k.getLimits().Overdraft
+}
+
+// resultCode returns the ResultCode for a charge of price when balance is the
+// balance before the charge. It returns AccountEmpty, if the charge must not be
+// made.
+func (k *Kasse) resultCode(user User, balance int64, price int) ResultCode {
+	after := balance - int64(price)
+	available := after + int64(k.overdraft(user))
+	switch {
+	case available < 0:
+		return AccountEmpty
+	case after < 0:
+		return Overdrawn
+	case available < int64(k.getLimits().LowBalance):
+		return LowBalance
+	default:
+		return PaymentMade
+	}
+}
+
+// withdraw takes amount (in cents) from the balance of user, like
+// adjustBalance, but only if that doesn't exceed their overdraft limit. The
+// check is part of the update, so that concurrent charges can't exceed the
+// limit together, which they could if each checked the balance it read
+// before. It returns the balance after the withdrawal, or ErrAccountEmpty if
+// the limit would be exceeded.
+func (k *Kasse) withdraw(tx *sqlx.Tx, user User, amount int) (int64, error) {
+	result, err := tx.Exec(`UPDATE users SET balance = balance - $1 WHERE user_id = $2 AND balance - $1 >= $3`, amount, user.ID, -k.overdraft(user))
+	if err != nil {
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		return 0, ErrAccountEmpty
+	}
+	return getBalance(tx, user.ID)
+}
+
+// SetOverdraft sets the personal overdraft limit (in cents) of the user with
+// the given id on behalf of actor. If limit is not valid, the global default
+// applies to them. It returns ErrUserNotFound if there is no such user.
+func (k *Kasse) SetOverdraft(actor User, id int, limit sql.NullInt64) error {
+	k.log.Printf("Setting overdraft of user %d to %v", id, limit)
+
+	if limit.Valid && limit.Int64 < 0 {
+		return ErrInvalidAmount
+	}
+
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var old sql.NullInt64
+	if err := tx.Get(&old, `SELECT overdraft FROM users WHERE user_id = $1`, id); err == sql.ErrNoRows {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET overdraft = $1 WHERE user_id = $2`, limit, id); err != nil {
+		return err
+	}
+	if err := k.audit(tx, actor, "user.overdraft", fmt.Sprintf("user %d", id), overdraftAuditValue(old), overdraftAuditValue(limit)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// overdraftAuditValue returns the overdraft limit recorded in the audit log.
+// It is null, if the default applies.
+func overdraftAuditValue(limit sql.NullInt64) interface{} {
+	v := map[string]interface{}{"overdraft": nil}
+	if limit.Valid {
+		v["overdraft"] = limit.Int64
+	}
+	return v
+}
+
+// GetUsers gets all users, ordered by name.
+func (k *Kasse) GetUsers() ([]User, error) {
+	var users []User
+	if err := k.db.Select(&users, `SELECT user_id, name, password, overdraft FROM users ORDER BY name`); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
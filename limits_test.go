@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t), limits: &Limits{LowBalance: 200, Overdraft: 100}}
+	defer k.db.Close()
+
+	insertData(t, k.db, []User{
+		{ID: 1, Name: "Merovius", Password: []byte("password")},
+		{ID: 2, Name: "Koebi", Password: []byte("password1")},
+	}, []Card{
+		{ID: []byte("aaaa"), User: 1},
+		{ID: []byte("baaa"), User: 2},
+	}, []Transaction{
+		{ID: 1, User: 1, Time: time.Now(), Amount: 300, Kind: "Aufladung"},
+		{ID: 2, User: 2, Time: time.Now(), Amount: 300, Kind: "Aufladung"},
+	})
+	if _, err := k.AddProduct(User{}, "Mate", 100); err != nil {
+		t.Fatalf("could not add product: %v", err)
+	}
+
+	if err := k.SetOverdraft(User{}, 1, sql.NullInt64{Int64: 1000, Valid: true}); err != nil {
+		t.Fatalf("SetOverdraft(1, 1000) == %v, want nil", err)
+	}
+	if err := k.SetOverdraft(User{}, 23, sql.NullInt64{}); err != ErrUserNotFound {
+		t.Errorf("SetOverdraft(23, NULL) == %v, want %v", err, ErrUserNotFound)
+	}
+	if err := k.SetOverdraft(User{}, 2, sql.NullInt64{Int64: -1, Valid: true}); err != ErrInvalidAmount {
+		t.Errorf("SetOverdraft(2, -1) == %v, want %v", err, ErrInvalidAmount)
+	}
+
+	tcs := []struct {
+		input   []byte
+		wantErr error
+		want    ResultCode
+	}{
+		// Merovius may go down to -10€ and gets warned below 2€ of funds.
+		{[]byte("aaaa"), nil, PaymentMade},
+		{[]byte("aaaa"), nil, PaymentMade},
+		{[]byte("aaaa"), nil, PaymentMade},
+		{[]byte("aaaa"), nil, Overdrawn},
+		{[]byte("aaaa"), nil, Overdrawn},
+		// Koebi uses the default of -1€.
+		{[]byte("baaa"), nil, PaymentMade},
+		{[]byte("baaa"), nil, PaymentMade},
+		{[]byte("baaa"), nil, LowBalance},
+		{[]byte("baaa"), nil, Overdrawn},
+		{[]byte("baaa"), ErrAccountEmpty, AccountEmpty},
+	}
+
+	for _, tc := range tcs {
+		got, gotErr := k.HandleCard("", tc.input, nil)
+		if gotErr != tc.wantErr || got == nil || got.Code != tc.want {
+			t.Errorf("HandleCard(%s) == (%v, %v), want (%v, %v)", string(tc.input), got, gotErr, tc.want, tc.wantErr)
+		}
+	}
+
+	users, err := k.GetUsers()
+	if err != nil || len(users) != 2 {
+		t.Fatalf("GetUsers() == (%v, %v), want 2 users", users, err)
+	}
+	if users[0].Name != "Koebi" || users[0].Overdraft.Valid {
+		t.Errorf("GetUsers()[0] == %v, want Koebi without overdraft", users[0])
+	}
+	if users[1].Name != "Merovius" || users[1].Overdraft.Int64 != 1000 {
+		t.Errorf("GetUsers()[1] == %v, want Merovius with overdraft 1000", users[1])
+	}
+}
+
+func TestWithdraw(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t), limits: &Limits{LowBalance: 200, Overdraft: 100}}
+	defer k.db.Close()
+
+	mero := User{ID: 1, Name: "Merovius", Password: []byte("password")}
+	insertData(t, k.db, []User{mero}, nil, []Transaction{
+		{ID: 1, User: 1, Time: time.Now(), Amount: 300, Kind: "Aufladung"},
+	})
+
+	// The amounts are checked against the stored balance, not one read
+	// before, so that charges racing each other can't exceed the limit.
+	tcs := []struct {
+		amount  int
+		wantErr error
+		balance int64
+	}{
+		{250, nil, 50},
+		{250, ErrAccountEmpty, 50},
+		{150, nil, -100},
+		{1, ErrAccountEmpty, -100},
+	}
+	for _, tc := range tcs {
+		tx, err := k.db.Beginx()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := k.withdraw(tx, mero, tc.amount)
+		if err != tc.wantErr || (err == nil && got != tc.balance) {
+			t.Errorf("withdraw(%d) == (%d, %v), want (%d, %v)", tc.amount, got, err, tc.balance, tc.wantErr)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := k.GetBalance(mero); err != nil || b != tc.balance {
+			t.Errorf("GetBalance(%v) after withdraw(%d) == (%d, %v), want (%d, nil)", mero.Name, tc.amount, b, err, tc.balance)
+		}
+	}
+}
@@ -7,9 +7,10 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/Merovius/go-misc/lcd2usb"
@@ -17,26 +18,58 @@ import (
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/sessions"
 	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
 
 var (
 	// Defaults for development
-	driver   = flag.String("sql-driver", "sqlite3", "The SQL driver to use for the database")
+	driver   = flag.String("sql-driver", "sqlite3", "The SQL driver to use for the database (sqlite3 or postgres)")
 	connect  = flag.String("connect", "kasse.sqlite", "The connection specification for the database")
 	listen   = flag.String("listen", "localhost:9000", "Where to listen for HTTP connections")
 	hardware = flag.Bool("hardware", true, "Whether hardware is plugged in")
+	display  = flag.String("display", "", "Where to show swipe results: lcd, log or none. Defaults to lcd with -hardware and log otherwise")
+	readers  = make(readerFlag)
+	// readerProducts are the ids of the products charged at each reader, as
+	// given by -reader-product.
+	readerProducts = make(readerFlag)
+	debounce       = flag.Duration("debounce", time.Second, "How long a card has to be removed from the reader, before it is charged again")
+
+	migrateOnly   = flag.Bool("migrate-only", false, "Only apply database migrations and exit")
+	makeTreasurer = flag.String("make-treasurer", "", "Make the user with this name a treasurer on startup, e.g. to bootstrap the first one. Treasurers can't approve their own top-ups, so make a second one")
+	checkBalances = flag.Bool("check-balances", false, "Only check the stored balances against the transactions and exit, with a non-zero status if they differ")
+	verifyAudit   = flag.Bool("verify-audit-log", false, "Only verify the hash chain of the audit log and exit, with a non-zero status if it is broken")
+	auditKey      = flag.String("audit-key", "", "File with the hex-encoded key of the audit log hashes. Defaults to $"+AuditKeyEnv)
+
+	undoWindow   = flag.Duration("undo-window", DefaultUndoWindow, "How long after a swipe it can be undone on the dashboard")
+	cancelWindow = flag.Duration("cancel-window", DefaultCancelWindow, "How long after a swipe it can be cancelled by swiping the card again at the same reader")
+
+	sessionKeys    = flag.String("session-keys", "", "File with hex-encoded session key pairs (signing and encryption key per line, current pair first). Defaults to $"+SessionKeysEnv+" (pairs separated by commas) or random keys")
+	sessionMaxAge  = flag.Duration("session-max-age", 30*24*time.Hour, "How long a login stays valid")
+	cookieSecure   = flag.Bool("cookie-secure", false, "Only send the session cookie over HTTPS")
+	trustedProxies = flag.String("trusted-proxies", "", "Comma-separated IP addresses or networks of reverse proxies, whose X-Forwarded-For and X-Real-IP headers are trusted to give the address of the client. Failed logins are throttled per client address")
+	cookieSameSite = flag.String("cookie-samesite", "lax", "SameSite attribute of the session cookie: lax, strict or none. none requires -cookie-secure")
+
+	displayTime = flag.Duration("display-time", time.Second, "How long swipe results are shown on the display")
+	kioskToken  = flag.String("kiosk-token", "", "Secret the kiosk display has to open /kiosk?token= with to see the swipes. The kiosk is disabled without it")
+
+	lowBalance = flag.Int("low-balance", DefaultLimits.LowBalance, "Warn on swipes leaving less than this many cents to spend")
+	overdraft  = flag.Int("overdraft", DefaultLimits.Overdraft, "How many cents users without a personal limit may go below zero")
 )
 
 func init() {
 	gob.Register(User{})
+	flag.Var(readers, "reader", "A reader to poll, as name=connstring with a libnfc connstring. Can be given multiple times. Defaults to the first available reader")
+	flag.Var(readerProducts, "reader-product", "Charge the product with this id for swipes at a reader, as name=id, instead of the default product. Can be given multiple times")
 }
 
-// NFCEvent contains an event at the NFC reader. Either UID or Err is nil.
+// NFCEvent contains an event at an NFC reader. Either UID or Err is nil.
 type NFCEvent struct {
-	UID []byte
-	Err error
+	// Reader is the name of the reader the event happened at.
+	Reader string
+	UID    []byte
+	Err    error
 }
 
 // Kasse collects all state of the application in a central type, to make
@@ -45,6 +78,26 @@ type Kasse struct {
 	db       *sqlx.DB
 	log      *log.Logger
 	sessions sessions.Store
+	limits   *Limits
+	kiosk    Kiosk
+	throttle loginThrottle
+	// undoWindow is how long after a swipe it can be undone. If it is zero,
+	// DefaultUndoWindow applies.
+	undoWindow time.Duration
+	// cancelWindow is how long after a swipe it can be cancelled by swiping
+	// the card again. If it is zero, DefaultCancelWindow applies.
+	cancelWindow time.Duration
+	// kioskToken is the secret the kiosk display has to give. If it is empty,
+	// the kiosk is disabled.
+	kioskToken string
+	// readerProducts maps reader names to the id of the product charged for
+	// swipes at them. Readers not in it charge the default product.
+	readerProducts map[string]int
+	// trustedProxies are the networks of reverse proxies, whose forwarded
+	// client addresses are trusted.
+	trustedProxies []*net.IPNet
+	// auditKey is the key of the HMACs chaining the audit log.
+	auditKey []byte
 }
 
 // User represents a user in the system (as in the database schema).
@@ -52,6 +105,9 @@ type User struct {
 	ID       int    `db:"user_id"`
 	Name     string `db:"name"`
 	Password []byte `db:"password"`
+	// Overdraft is the personal overdraft limit in cents. If it is not valid,
+	// the configured default applies.
+	Overdraft sql.NullInt64 `db:"overdraft"`
 }
 
 // Card represents a card in the system (as in the database schema).
@@ -59,17 +115,37 @@ type Card struct {
 	ID          []byte `db:"card_id"`
 	User        int    `db:"user_id"`
 	Description string `db:"description"`
+	// Blocked is set for lost or stolen cards, which can't be used for
+	// payment.
+	Blocked bool `db:"blocked"`
 }
 
 // Transaction represents a transaction in the system (as in the database
 // schema).
 type Transaction struct {
-	ID     int       `db:"transaction_id"`
-	User   int       `db:"user_id"`
-	Card   []byte    `db:"card_id"`
-	Time   time.Time `db:"time"`
-	Amount int       `db:"amount"`
-	Kind   string    `db:"kind"`
+	ID      int           `db:"transaction_id"`
+	User    int           `db:"user_id"`
+	Card    []byte        `db:"card_id"`
+	Time    time.Time     `db:"time"`
+	Amount  int           `db:"amount"`
+	Kind    string        `db:"kind"`
+	Product sql.NullInt64 `db:"product_id"`
+	// Reader is the name of the reader the card was swiped at, if any.
+	Reader sql.NullString `db:"reader"`
+	// Reverses is the transaction undone by this one, if any.
+	Reverses sql.NullInt64 `db:"reverses"`
+	// Counterpart is the other half of a transfer, if any.
+	Counterpart sql.NullInt64 `db:"counterpart_id"`
+
+	// ProductName is the name of Product. It is not part of the table, but
+	// filled in by GetTransactions.
+	ProductName sql.NullString `db:"product_name"`
+	// Counterparty is the name of the user owning Counterpart. It is not part
+	// of the table, but filled in by GetTransactions.
+	Counterparty sql.NullString `db:"counterparty"`
+	// Balance is the balance of the account right after this transaction. It
+	// is not part of the table, but filled in by GetTransactions.
+	Balance int64 `db:"-"`
 }
 
 // ResultCode is the action taken by a swipe of a card. It should be
@@ -87,41 +163,37 @@ const (
 	// AccountEmpty means the charge was not applied, because there are not
 	// enough funds left in the account.
 	AccountEmpty
+	// Overdrawn means the charge was applied successfully, but the account is
+	// now below zero and uses the overdraft limit of the user.
+	Overdrawn
+	// SwipeUndone means a previous charge of the card has been undone,
+	// because it was swiped again at the same reader within the cancel window.
+	SwipeUndone
 )
 
 // Result is the action taken by a swipe of a card. It contains all information
 // to be communicated to the user.
 type Result struct {
 	Code    ResultCode
+	Reader  string
 	UID     []byte
 	User    string
+	Product string
+	// Account is the balance (in euros) after the swipe, for displays.
 	Account float32
+	// Balance is the balance (in cents) after the swipe.
+	Balance int64
 }
 
-func flashLCD(lcd *lcd2usb.Device, text string, r, g, b uint8) error {
-	lcd.Color(r, g, b)
-	for i, l := range strings.Split(text, "\n") {
-		if len(l) > 16 {
-			l = l[:16]
-		}
-		if i > 2 {
-			break
-		}
-		lcd.CursorPosition(1, uint8(i+1))
-		fmt.Fprint(lcd, l)
-	}
-	// TODO: Make flag
-	time.Sleep(time.Second)
-	lcd.Color(0, 0, 255)
-	lcd.Clear()
-	return nil
-}
-
-// Print writes the result to a 16x2 LCD display.
-func (res *Result) Print(lcd *lcd2usb.Device) error {
+// Print shows the result on d.
+func (res *Result) Print(d Display) error {
 	var r, g, b uint8
 	// TODO(mero): Make sure format does not overflow (floating point)
 	text := fmt.Sprintf("Card: %x\n%-9s%.2fE", res.UID, res.User, res.Account)
+	if res.Code == SwipeUndone {
+		// Make sure nobody mistakes an undo for another charge.
+		text = fmt.Sprintf("UNDONE %.9s\n%-9s%.2fE", res.Product, res.User, res.Account)
+	}
 	switch res.Code {
 	default:
 		r, g, b = 255, 255, 255
@@ -131,8 +203,12 @@ func (res *Result) Print(lcd *lcd2usb.Device) error {
 		r, g, b = 255, 50, 0
 	case AccountEmpty:
 		r, g, b = 255, 0, 0
+	case Overdrawn:
+		r, g, b = 255, 0, 255
+	case SwipeUndone:
+		r, g, b = 0, 255, 255
 	}
-	return flashLCD(lcd, text, r, g, b)
+	return flash(d, text, r, g, b)
 }
 
 // String implements fmt.Stringer.
@@ -144,6 +220,10 @@ func (r ResultCode) String() string {
 		return "LowBalance"
 	case AccountEmpty:
 		return "AccountEmpty"
+	case Overdrawn:
+		return "Overdrawn"
+	case SwipeUndone:
+		return "SwipeUndone"
 	default:
 		return fmt.Sprintf("Result(%d)", r)
 	}
@@ -157,6 +237,10 @@ var ErrAccountEmpty = errors.New("account is empty")
 // registered to any user.
 var ErrCardNotFound = errors.New("card not found")
 
+// ErrCardBlocked means the charge couldn't be applied because the card has
+// been marked as lost or blocked by its owner.
+var ErrCardBlocked = errors.New("card is blocked")
+
 // ErrUserExists means that a duplicate username was tried to register.
 var ErrUserExists = errors.New("username already taken")
 
@@ -168,14 +252,32 @@ var ErrCardExists = errors.New("card already registered")
 // Authentication.
 var ErrWrongAuth = errors.New("wrong username or password")
 
-// HandleCard handles the swiping of a new card. It looks up the user the card
-// belongs to and checks the account balance. It returns PaymentMade, when the
-// account has been charged correctly, LowBalance if there is less than 5€ left
-// after the charge (the charge is still made) and AccountEmpty when there is
-// no balance left on the account. The account is charged if and only if the
-// returned error is nil.
-func (k *Kasse) HandleCard(uid []byte) (*Result, error) {
-	k.log.Printf("Card %x was swiped", uid)
+// HandleCard handles the swiping of a new card at the given reader. It looks up
+// the user the card belongs to and checks the account balance. The price of
+// product is charged. If product is nil, the product configured for reader is
+// charged, or the default product if there is none. It returns PaymentMade,
+// when the account has been charged correctly, LowBalance if less than the
+// configured LowBalance can be spent after the charge, Overdrawn if the
+// balance is below zero after the charge (the charge is still made in both
+// cases) and AccountEmpty when the charge would exceed the overdraft limit of
+// the user. The account is charged and the stock of product decremented if
+// and only if the returned error is nil. Every Result is also published to the
+// kiosk displays.
+func (k *Kasse) HandleCard(reader string, uid []byte, product *Product) (*Result, error) {
+	k.log.Printf("Card %x was swiped at reader %q", uid, reader)
+
+	if product == nil {
+		var err error
+		if id, ok := k.readerProducts[reader]; ok {
+			product, err = k.GetProduct(id)
+		} else {
+			product, err = k.DefaultProduct()
+		}
+		if err != nil {
+			k.log.Printf("Could not get product for reader %q: %v", reader, err)
+			return nil, err
+		}
+	}
 
 	tx, err := k.db.Beginx()
 	if err != nil {
@@ -184,51 +286,71 @@ func (k *Kasse) HandleCard(uid []byte) (*Result, error) {
 	defer tx.Rollback()
 
 	// Get user this card belongs to
-	var user User
-	if err := tx.Get(&user, `SELECT users.user_id, name, password FROM cards LEFT JOIN users ON cards.user_id = users.user_id WHERE card_id = $1`, uid); err != nil {
+	var card struct {
+		User
+		Blocked bool `db:"blocked"`
+	}
+	if err := tx.Get(&card, `SELECT users.user_id, name, password, overdraft, blocked FROM cards LEFT JOIN users ON cards.user_id = users.user_id WHERE card_id = $1`, uid); err != nil {
 		k.log.Println("Card not found in database")
 		return nil, ErrCardNotFound
 	}
+	user := card.User
 	k.log.Printf("Card belongs to %v", user.Name)
 
+	if card.Blocked {
+		k.log.Println("Card is blocked")
+		return nil, ErrCardBlocked
+	}
+
 	// Get account balance of this user
-	var balance int64
-	var b sql.NullInt64
-	if err := tx.Get(&b, `SELECT SUM(amount) FROM transactions WHERE user_id = $1`, user.ID); err != nil {
+	balance, err := getBalance(tx, user.ID)
+	if err != nil {
 		k.log.Println("Could not get balance:", err)
 		return nil, err
 	}
-	if b.Valid {
-		balance = b.Int64
-	}
 	k.log.Printf("Account balance is %d", balance)
 
 	res := &Result{
+		Reader:  reader,
 		UID:     uid,
 		User:    user.Name,
+		Product: product.Name,
 		Account: float32(balance) / 100,
+		Balance: balance,
 	}
-	if balance < 100 {
-		res.Code = AccountEmpty
+	res.Code = k.resultCode(user, balance, product.Price)
+	if res.Code == AccountEmpty {
+		k.kiosk.Publish(res)
 		return res, ErrAccountEmpty
 	}
 
 	// Insert new transaction
-	if _, err := tx.Exec(`INSERT INTO transactions (user_id, card_id, time, amount, kind) VALUES ($1, $2, $3, $4, $5)`, user.ID, uid, time.Now(), -100, "Kartenswipe"); err != nil {
+	if _, err := tx.Exec(`INSERT INTO transactions (user_id, card_id, time, amount, kind, product_id, reader) VALUES ($1, $2, $3, $4, $5, $6, $7)`, user.ID, uid, time.Now(), -product.Price, "Kartenswipe", product.ID, reader); err != nil {
 		return nil, err
 	}
+	// The balance might have changed since we read it, so the charge is
+	// checked again and the result based on the balance after it.
+	after, err := k.withdraw(tx, user, product.Price)
+	if err == ErrAccountEmpty {
+		res.Code = AccountEmpty
+		k.kiosk.Publish(res)
+		return res, ErrAccountEmpty
+	} else if err != nil {
+		return nil, err
+	}
+	if err := adjustStock(tx, product.ID, -1); err != nil {
+		return nil, err
+	}
+	res.Code = k.resultCode(user, after+int64(product.Price), product.Price)
+	res.Account = float32(after) / 100
+	res.Balance = after
 
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
 
-	if balance < 600 {
-		k.log.Println("balance is low")
-		res.Code = LowBalance
-	} else {
-		res.Code = PaymentMade
-	}
-	k.log.Println("returning")
+	k.log.Printf("returning %v", res.Code)
+	k.kiosk.Publish(res)
 	return res, nil
 }
 
@@ -258,23 +380,17 @@ func (k *Kasse) RegisterUser(name string, password []byte) (*User, error) {
 		return nil, err
 	}
 
-	result, err := tx.Exec(`INSERT INTO users (name, password) VALUES ($1, $2)`, name, pwhash)
-	if err != nil {
+	if err := tx.Get(&user.ID, `INSERT INTO users (name, password) VALUES ($1, $2) RETURNING user_id`, name, pwhash); err != nil {
 		return nil, err
 	}
-
-	id, err := result.LastInsertId()
-	if err != nil {
-		if err := tx.Get(&id, `SELECT user_id FROM users WHERE name = $1`, name); err != nil {
-			return nil, err
-		}
+	if err := k.audit(tx, user, "user.register", fmt.Sprintf("user %d", user.ID), nil, map[string]interface{}{"name": name}); err != nil {
+		return nil, err
 	}
 
 	if err := tx.Commit(); err != nil {
 		return nil, err
 	}
 
-	user.ID = int(id)
 	user.Name = name
 	user.Password = pwhash
 	return &user, nil
@@ -305,6 +421,9 @@ func (k *Kasse) AddCard(uid []byte, owner *User) (*Card, error) {
 	if _, err := tx.Exec(`INSERT INTO cards (card_id, user_id, description) VALUES ($1, $2, '')`, uid, owner.ID); err != nil {
 		return nil, err
 	}
+	if err := k.audit(tx, *owner, "card.add", fmt.Sprintf("card %x", uid), nil, cardAuditValue(Card{ID: uid, User: owner.ID})); err != nil {
+		return nil, err
+	}
 
 	if err := tx.Commit(); err != nil {
 		return nil, err
@@ -329,7 +448,7 @@ func (k *Kasse) Authenticate(username string, password []byte) (*User, error) {
 	}()
 
 	user := new(User)
-	if err := k.db.Get(user, `SELECT user_id, name, password FROM users WHERE name = $1`, username); err == sql.ErrNoRows {
+	if err := k.db.Get(user, `SELECT user_id, name, password, overdraft FROM users WHERE name = $1`, username); err == sql.ErrNoRows {
 		k.log.Printf("No such user %v", username)
 		return nil, ErrWrongAuth
 	} else if err != nil {
@@ -350,7 +469,7 @@ func (k *Kasse) Authenticate(username string, password []byte) (*User, error) {
 // GetCards gets all cards for a given user.
 func (k *Kasse) GetCards(user User) ([]Card, error) {
 	var cards []Card
-	if err := k.db.Select(&cards, `SELECT card_id, user_id, description FROM cards WHERE user_id = $1`, user.ID); err != nil {
+	if err := k.db.Select(&cards, `SELECT card_id, user_id, description, blocked FROM cards WHERE user_id = $1`, user.ID); err != nil {
 		return nil, err
 	}
 	return cards, nil
@@ -358,28 +477,18 @@ func (k *Kasse) GetCards(user User) ([]Card, error) {
 
 // GetBalance gets the current balance for a given user.
 func (k *Kasse) GetBalance(user User) (int64, error) {
-	var b sql.NullInt64
-	if err := k.db.Get(&b, `SELECT SUM(amount) FROM transactions WHERE user_id = $1`, user.ID); err != nil {
+	b, err := getBalance(k.db, user.ID)
+	if err != nil {
 		k.log.Println("Could not get balance:", err)
 		return 0, err
 	}
-	return b.Int64, nil
+	return b, nil
 }
 
 // GetTransactions gets the last n transactions for a given user. If n ≤ 0, all
 // transactions are returnsed.
 func (k *Kasse) GetTransactions(user User, n int) ([]Transaction, error) {
-	var transactions []Transaction
-	var err error
-	if n <= 0 {
-		err = k.db.Select(&transactions, `SELECT user_id, card_id, time, amount, kind FROM transactions WHERE user_id = $1 ORDER BY time DESC`, user.ID)
-	} else {
-		err = k.db.Select(&transactions, `SELECT user_id, card_id, time, amount, kind FROM transactions WHERE user_id = $1 ORDER BY time DESC LIMIT $2`, user.ID, n)
-	}
-	if err != nil {
-		return nil, err
-	}
-	return transactions, nil
+	return k.QueryTransactions(TransactionQuery{User: user.ID, Limit: n})
 }
 
 func main() {
@@ -387,6 +496,30 @@ func main() {
 
 	k := new(Kasse)
 	k.log = log.New(os.Stderr, "", log.LstdFlags)
+	k.limits = &Limits{
+		LowBalance: *lowBalance,
+		Overdraft:  *overdraft,
+	}
+	k.undoWindow = *undoWindow
+	k.cancelWindow = *cancelWindow
+	proxies, err := parseTrustedProxies(*trustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	k.trustedProxies = proxies
+	k.kioskToken = *kioskToken
+	if k.kioskToken == "" {
+		log.Println("No kiosk token given, the kiosk display is disabled.")
+	}
+
+	key, err := LoadAuditKey(*auditKey)
+	if err != nil {
+		log.Fatal("Could not load audit key:", err)
+	}
+	if key == nil {
+		log.Println("No audit key given. Anyone with write access to the database can rewrite the audit log undetected.")
+	}
+	k.auditKey = key
 
 	if db, err := sqlx.Connect(*driver, *connect); err != nil {
 		log.Fatal("Could not open database:", err)
@@ -399,26 +532,112 @@ func main() {
 		}
 	}()
 
-	k.sessions = sessions.NewCookieStore([]byte("TODO: Set up safer password"))
-	http.Handle("/", handlers.LoggingHandler(os.Stderr, k.Handler()))
+	if err := Migrate(k.db); err != nil {
+		log.Fatal("Could not migrate database:", err)
+	}
+	if *migrateOnly {
+		return
+	}
+	if *makeTreasurer != "" {
+		if err := k.PromoteTreasurer(*makeTreasurer); err != nil {
+			log.Fatalf("Could not make %s a treasurer: %v", *makeTreasurer, err)
+		}
+	}
+	if *checkBalances {
+		drift, err := k.CheckBalances()
+		if err != nil {
+			log.Fatal("Could not check balances:", err)
+		}
+		if len(drift) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+	if *verifyAudit {
+		if err := k.VerifyAuditLog(); err != nil {
+			log.Fatal("Audit log is broken:", err)
+		}
+		log.Println("Audit log is intact")
+		return
+	}
 
-	var lcd *lcd2usb.Device
-	if *hardware {
-		var err error
-		if lcd, err = lcd2usb.Open("/dev/ttyACM0", 2, 16); err != nil {
+	k.readerProducts = make(map[string]int)
+	for name, v := range readerProducts {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("Invalid product id %q for reader %q", v, name)
+		}
+		if _, err := k.GetProduct(id); err != nil {
+			log.Fatalf("Could not get product %d for reader %q: %v", id, name, err)
+		}
+		k.readerProducts[name] = id
+	}
+
+	keys, err := LoadSessionKeys(*sessionKeys)
+	if err != nil {
+		log.Fatal("Could not load session keys:", err)
+	}
+	if *sessionKeys == "" && os.Getenv(SessionKeysEnv) == "" {
+		log.Println("No session keys given, using random keys. Logins won't survive a restart.")
+	}
+	sameSite, err := parseSameSite(*cookieSameSite)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sessionConfig := SessionConfig{
+		Keys:     keys,
+		MaxAge:   *sessionMaxAge,
+		Secure:   *cookieSecure,
+		SameSite: sameSite,
+	}
+	if err := sessionConfig.Validate(); err != nil {
+		log.Fatalf("Invalid cookie settings: %v. Use -cookie-secure with -cookie-samesite none", err)
+	}
+	k.sessions = NewSessionStore(sessionConfig)
+	http.Handle("/", redactTokens(handlers.LoggingHandler(os.Stderr, k.Handler())))
+
+	if *display == "" {
+		*display = "log"
+		if *hardware {
+			*display = "lcd"
+		}
+	}
+	var disp Display
+	switch *display {
+	case "lcd":
+		lcd, err := lcd2usb.Open("/dev/ttyACM0", 2, 16)
+		if err != nil {
 			log.Fatal(err)
 		}
+		disp = LCDDisplay{lcd}
+	case "log":
+		disp = LogDisplay{log.New(os.Stderr, "", log.LstdFlags)}
+	case "none":
+		disp = NopDisplay{}
+	default:
+		log.Fatalf("Unknown display %q", *display)
 	}
 
+	polls := make(chan NFCEvent)
 	events := make(chan NFCEvent)
-	// We have to wrap the call in a func(), because the go statement evaluates
-	// it's arguments in the current goroutine, and the argument to log.Fatal
-	// blocks in these cases.
 	if *hardware {
-		go func() {
-			log.Fatal(ConnectAndPollNFCReader("", events))
-		}()
+		if len(readers) == 0 {
+			readers["default"] = ""
+		}
+		for name, conn := range readers {
+			r, err := OpenNFCReader(conn)
+			if err != nil {
+				log.Fatalf("Could not open reader %q: %v", name, err)
+			}
+			// We have to wrap the call in a func(), because the go statement
+			// evaluates it's arguments in the current goroutine, and the
+			// argument to log.Fatal blocks in these cases.
+			go func(name string, r Reader) {
+				log.Fatal(PollReader(name, r, polls))
+			}(name, r)
+		}
 	}
+	go Debounce(polls, events, *debounce)
 
 	RegisterHTTPReader(k)
 	go func() {
@@ -429,16 +648,23 @@ func main() {
 	for {
 		ev := <-events
 		if ev.Err != nil {
-			log.Println(ev.Err)
+			log.Printf("Error at reader %q: %v", ev.Reader, ev.Err)
 			continue
 		}
 
-		res, err := k.HandleCard(ev.UID)
+		res, err := k.Swipe(ev.Reader, ev.UID)
 		if res != nil {
-			res.Print(lcd)
+			res.Print(disp)
+		} else if err == ErrCardNotFound {
+			if code, err := k.NewPairingCode(ev.UID); err != nil {
+				log.Println("Could not create pairing code:", err)
+				flash(disp, ErrCardNotFound.Error(), 255, 0, 0)
+			} else {
+				disp.Show("Unknown card\nCode: "+code, 255, 255, 255, 10*time.Second)
+			}
 		} else {
 			// TODO: Distinguish between user-facing errors and internal errors
-			flashLCD(lcd, err.Error(), 255, 0, 0)
+			flash(disp, err.Error(), 255, 0, 0)
 		}
 	}
 }
@@ -2,12 +2,17 @@ package main
 
 import (
 	"bytes"
-	"errors"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -32,7 +37,7 @@ type TestReader []struct {
 
 func (t *TestReader) GetNextUID() ([]byte, error) {
 	if len(*t) == 0 {
-		return nil, errors.New("no uids left")
+		return nil, io.EOF
 	}
 	h := (*t)[0]
 	*t = (*t)[1:]
@@ -43,14 +48,64 @@ func (t *TestReader) Close() error {
 	return nil
 }
 
-func createDB(t *testing.T) *sqlx.DB {
+// postgresEnv is the name of an environment variable, that can be set to the
+// connection string of a PostgreSQL database, to run the tests against it
+// instead of in-memory SQLite databases.
+const postgresEnv = "KASSE_TEST_POSTGRES"
+
+// postgresSchemas counts the schemas created by createDB, to give them unique
+// names.
+var postgresSchemas int64
+
+func createDB(t testing.TB) *sqlx.DB {
+	if conn := os.Getenv(postgresEnv); conn != "" {
+		return createPostgresDB(t, conn)
+	}
+
 	db, err := sqlx.Connect("sqlite3", ":memory:")
 	if err != nil {
 		t.Fatalf("could not create in-memory database: %v", err)
 	}
-	_, err = sqlx.LoadFile(db, "schema.sql")
+	if err := Migrate(db); err != nil {
+		t.Fatalf("could not migrate database: %v", err)
+	}
+	return db
+}
+
+// createPostgresDB creates a schema in the PostgreSQL database given by conn,
+// that is only used by t and dropped when it finishes. It returns a migrated
+// database using that schema.
+func createPostgresDB(t testing.TB, conn string) *sqlx.DB {
+	admin, err := sqlx.Connect("postgres", conn)
 	if err != nil {
-		t.Fatalf("could not load schema: %v", err)
+		t.Fatalf("could not connect to postgres: %v", err)
+	}
+	schema := fmt.Sprintf("kasse_test_%d_%d", os.Getpid(), atomic.AddInt64(&postgresSchemas, 1))
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("could not create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("could not drop schema: %v", err)
+		}
+		admin.Close()
+	})
+
+	// Unknown parameters are passed on to the server, so search_path selects
+	// the schema for all connections of the pool.
+	sep := " "
+	if strings.Contains(conn, "://") {
+		sep = "?"
+		if strings.Contains(conn, "?") {
+			sep = "&"
+		}
+	}
+	db, err := sqlx.Connect("postgres", conn+sep+"search_path="+schema)
+	if err != nil {
+		t.Fatalf("could not connect to postgres: %v", err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatalf("could not migrate database: %v", err)
 	}
 	return db
 }
@@ -63,16 +118,31 @@ func insertData(t *testing.T, db *sqlx.DB, us []User, cs []Card, ts []Transactio
 		}
 	}
 	for _, v := range cs {
-		_, err := db.Exec("INSERT INTO cards (card_id, user_id) VALUES ($1, $2)", v.ID, v.User)
+		_, err := db.Exec("INSERT INTO cards (card_id, user_id, description, blocked) VALUES ($1, $2, $3, $4)", v.ID, v.User, v.Description, v.Blocked)
 		if err != nil {
 			t.Fatalf("could not insert card %v: %v", v, err)
 		}
 	}
 	for _, v := range ts {
-		_, err := db.Exec("INSERT INTO transactions (transaction_id, user_id, card_id, time, amount, kind) VALUES ($1, $2, $3, $4, $5, $6)", v.ID, v.User, v.Card, v.Time, v.Amount, v.Kind)
+		_, err := db.Exec("INSERT INTO transactions (transaction_id, user_id, card_id, time, amount, kind, product_id, reverses) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)", v.ID, v.User, v.Card, v.Time, v.Amount, v.Kind, v.Product, v.Reverses)
 		if err != nil {
 			t.Fatalf("could not insert transaction %v: %v", v, err)
 		}
+		if err := adjustBalance(db, v.User, v.Amount); err != nil {
+			t.Fatalf("could not update balance for transaction %v: %v", v, err)
+		}
+	}
+
+	// Explicit ids don't advance the sequences of SERIAL columns.
+	if isPostgres(db) {
+		for _, q := range []string{
+			`SELECT setval(pg_get_serial_sequence('users', 'user_id'), COALESCE(MAX(user_id), 0) + 1, false) FROM users`,
+			`SELECT setval(pg_get_serial_sequence('transactions', 'transaction_id'), COALESCE(MAX(transaction_id), 0) + 1, false) FROM transactions`,
+		} {
+			if _, err := db.Exec(q); err != nil {
+				t.Fatalf("could not reset sequence: %v", err)
+			}
+		}
 	}
 }
 
@@ -95,6 +165,9 @@ func TestHandleCard(t *testing.T) {
 		{ID: 2, User: 1, Card: []byte("aaaa"), Time: time.Date(2015, 04, 06, 23, 05, 27, 0, time.FixedZone("TST", 3600)), Amount: -100, Kind: "Kartenswipe"},
 		{ID: 3, User: 1, Card: []byte("aaab"), Time: time.Date(2015, 04, 06, 22, 59, 03, 0, time.FixedZone("TST", 3600)), Amount: -100, Kind: "Kartenswipe"},
 	})
+	if _, err := k.AddProduct(User{}, "Mate", 100); err != nil {
+		t.Fatalf("could not add product: %v", err)
+	}
 
 	tcs := []struct {
 		input   []byte
@@ -117,7 +190,7 @@ func TestHandleCard(t *testing.T) {
 	}
 
 	for _, tc := range tcs {
-		got, gotErr := k.HandleCard(tc.input)
+		got, gotErr := k.HandleCard("", tc.input, nil)
 		if tc.wantErr != nil {
 			if gotErr != tc.wantErr {
 				t.Errorf("HandleCard(%s) == (%v, %v), want (_, %v)", string(tc.input), got, gotErr, tc.wantErr)
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrUnsupportedDriver is returned by Migrate, if the database is neither
+// SQLite nor PostgreSQL.
+var ErrUnsupportedDriver = errors.New("unsupported SQL driver")
+
+// migration is one step in the evolution of the database schema. As the SQL
+// dialects differ in data types and in what ALTER TABLE supports, every
+// migration has statements for each supported database. The columns are
+// documented in the SQLite statements.
+type migration struct {
+	version     int
+	description string
+	sqlite      string
+	postgres    string
+}
+
+// migrations are all migrations of the database schema, in the order they
+// have to be applied. Existing migrations must never be changed, to evolve the
+// schema, append a new one.
+var migrations = []migration{
+	{1, "initial schema", `
+CREATE TABLE users (
+	-- users contains all user-data. An entry in this table corresponds to one
+	-- specific person. The account balance is reconstructed completely out of the
+	-- transactions table, to reduce duplication of information.
+
+
+	-- user_id is a sequential identifier.
+	user_id INTEGER NOT NULL,
+	-- name is the username used for display and login.
+	name TEXT UNIQUE,
+	-- password is a bcrypt-hashed password.
+	password BINARY,
+
+	-- constraints
+	PRIMARY KEY (user_id)
+);
+
+CREATE TABLE cards (
+	-- cards contains all card-data. An entry in this table corresponds to one
+	-- physical card. Every user can have an arbitrary number of cards.
+
+
+	-- card_id is a sequential identifier.
+	card_id BINARY NOT NULL,
+	-- user_id is the user this card belongs to.
+	user_id INTEGER,
+	-- description is a freetext to use as an identifier.
+	description TEXT,
+
+	-- constraints
+	PRIMARY KEY (card_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id)
+);
+
+CREATE TABLE transactions (
+	-- transactions contains all transactions.
+
+
+	-- transaction_id is a sequential identifier.
+	transaction_id INTEGER NOT NULL,
+	-- user_id is the user that made this transaction.
+	user_id INTEGER,
+	-- card_id is the card this transaction was made with, if any.
+	card_id INTEGER,
+	-- time is the server-time this transaction happened.
+	time DATETIME,
+	-- amount is the (potentially negative) amount (in cents) of this
+	-- transaction.
+	amount INTEGER,
+	-- kind describes how this transaction was made: via touching an nfc tag to
+	-- the reader or by manually adding an amount in the web-interface.
+	kind TEXT,
+
+	-- constraints
+	PRIMARY KEY (transaction_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id),
+	FOREIGN KEY (card_id) REFERENCES cards(card_id)
+);
+`, `
+CREATE TABLE users (
+	user_id SERIAL NOT NULL,
+	name TEXT UNIQUE,
+	password BYTEA,
+
+	-- constraints
+	PRIMARY KEY (user_id)
+);
+
+CREATE TABLE cards (
+	card_id BYTEA NOT NULL,
+	user_id INTEGER,
+	description TEXT,
+
+	-- constraints
+	PRIMARY KEY (card_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id)
+);
+
+CREATE TABLE transactions (
+	transaction_id SERIAL NOT NULL,
+	user_id INTEGER,
+	-- card_id is no foreign key, as PostgreSQL enforces them and
+	-- transactions are kept when their card is removed.
+	card_id BYTEA,
+	time TIMESTAMP WITH TIME ZONE,
+	amount INTEGER,
+	kind TEXT,
+
+	-- constraints
+	PRIMARY KEY (transaction_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id)
+);
+`},
+	{2, "top-ups, products, overdraft limits, card management, pairing codes, undo, transfers and password resets", `
+-- users
+-- treasurer is whether this user may approve top-up requests.
+ALTER TABLE users ADD COLUMN treasurer BOOLEAN NOT NULL DEFAULT 0;
+-- overdraft is the amount (in cents) this user may go below zero. If it is
+-- NULL, the configured default applies.
+ALTER TABLE users ADD COLUMN overdraft INTEGER;
+
+-- cards
+-- blocked is whether this card has been marked as lost or blocked by its
+-- owner. Blocked cards can't be used for payment.
+ALTER TABLE cards ADD COLUMN blocked BOOLEAN NOT NULL DEFAULT 0;
+
+-- transactions
+-- product_id is the product that was bought with this transaction, if any.
+ALTER TABLE transactions ADD COLUMN product_id INTEGER REFERENCES products(product_id);
+-- reader is the name of the reader the card was swiped at, if any.
+ALTER TABLE transactions ADD COLUMN reader TEXT;
+-- reverses is the transaction undone by this one, if any. Only
+-- transactions of kind 'Storno' reference another one.
+ALTER TABLE transactions ADD COLUMN reverses INTEGER REFERENCES transactions(transaction_id);
+-- counterpart_id is the other half of a transfer between two users. Only
+-- transactions of kind 'Überweisung' reference another one.
+ALTER TABLE transactions ADD COLUMN counterpart_id INTEGER REFERENCES transactions(transaction_id);
+
+CREATE TABLE products (
+	-- products contains the catalogue of things that can be bought by swiping
+	-- a card.
+
+
+	-- product_id is a sequential identifier.
+	product_id INTEGER NOT NULL,
+	-- name is the name of the product used for display.
+	name TEXT UNIQUE,
+	-- price is the amount (in cents) charged for one unit of this product.
+	price INTEGER,
+	-- is_default is whether this product is charged, when a card is swiped
+	-- without picking a product. At most one product should have it set.
+	is_default BOOLEAN NOT NULL DEFAULT 0,
+
+	-- constraints
+	PRIMARY KEY (product_id)
+);
+
+CREATE TABLE topups (
+	-- topups contains all requests to top up an account via the
+	-- web-interface. A request is only credited (as a transaction) after a
+	-- treasurer approved it.
+
+
+	-- topup_id is a sequential identifier.
+	topup_id INTEGER NOT NULL,
+	-- user_id is the user whose account should be topped up.
+	user_id INTEGER,
+	-- amount is the requested amount (in cents).
+	amount INTEGER,
+	-- requested is the server-time this request was made.
+	requested DATETIME,
+	-- state is one of 'pending', 'approved' or 'rejected'.
+	state TEXT,
+	-- decided_by is the treasurer that approved or rejected the request.
+	decided_by INTEGER,
+	-- decided is the server-time the request was approved or rejected.
+	decided DATETIME,
+	-- transaction_id is the transaction created on approval, if any.
+	transaction_id INTEGER,
+
+	-- constraints
+	PRIMARY KEY (topup_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id),
+	FOREIGN KEY (decided_by) REFERENCES users(user_id),
+	FOREIGN KEY (transaction_id) REFERENCES transactions(transaction_id)
+);
+
+CREATE TABLE pairing_codes (
+	-- pairing_codes contains the one-time codes shown on the display, when an
+	-- unknown card is swiped. A logged in user can enter such a code in the
+	-- web-interface to register the card.
+
+
+	-- code is the short numeric code shown to the user.
+	code TEXT NOT NULL,
+	-- card_id is the UID of the swiped card.
+	card_id BINARY NOT NULL,
+	-- created is the server-time the card was swiped.
+	created DATETIME,
+
+	-- constraints
+	PRIMARY KEY (code)
+);
+
+CREATE TABLE password_resets (
+	-- password_resets contains the one-time tokens of password reset links
+	-- created by treasurers. A token can be used once to set a new password.
+
+
+	-- token is the random hex-encoded token contained in the link.
+	token TEXT NOT NULL,
+	-- user_id is the user whose password can be reset.
+	user_id INTEGER NOT NULL,
+	-- created is the server-time the token was created.
+	created DATETIME,
+
+	-- constraints
+	PRIMARY KEY (token),
+	FOREIGN KEY (user_id) REFERENCES users(user_id)
+);
+`, `
+ALTER TABLE users ADD COLUMN treasurer BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN overdraft INTEGER;
+
+ALTER TABLE cards ADD COLUMN blocked BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE products (
+	product_id SERIAL NOT NULL,
+	name TEXT UNIQUE,
+	price INTEGER,
+	is_default BOOLEAN NOT NULL DEFAULT FALSE,
+
+	-- constraints
+	PRIMARY KEY (product_id)
+);
+
+ALTER TABLE transactions ADD COLUMN product_id INTEGER REFERENCES products(product_id);
+ALTER TABLE transactions ADD COLUMN reader TEXT;
+ALTER TABLE transactions ADD COLUMN reverses INTEGER REFERENCES transactions(transaction_id);
+ALTER TABLE transactions ADD COLUMN counterpart_id INTEGER REFERENCES transactions(transaction_id);
+
+CREATE TABLE topups (
+	topup_id SERIAL NOT NULL,
+	user_id INTEGER,
+	amount INTEGER,
+	requested TIMESTAMP WITH TIME ZONE,
+	state TEXT,
+	decided_by INTEGER,
+	decided TIMESTAMP WITH TIME ZONE,
+	transaction_id INTEGER,
+
+	-- constraints
+	PRIMARY KEY (topup_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id),
+	FOREIGN KEY (decided_by) REFERENCES users(user_id),
+	FOREIGN KEY (transaction_id) REFERENCES transactions(transaction_id)
+);
+
+CREATE TABLE pairing_codes (
+	code TEXT NOT NULL,
+	card_id BYTEA NOT NULL,
+	created TIMESTAMP WITH TIME ZONE,
+
+	-- constraints
+	PRIMARY KEY (code)
+);
+
+CREATE TABLE password_resets (
+	token TEXT NOT NULL,
+	user_id INTEGER NOT NULL,
+	created TIMESTAMP WITH TIME ZONE,
+
+	-- constraints
+	PRIMARY KEY (token),
+	FOREIGN KEY (user_id) REFERENCES users(user_id)
+);
+`},
+	{3, "store card_id of transactions as BINARY, like in cards", `
+CREATE TABLE transactions_new (
+	-- transactions contains all transactions.
+
+
+	-- transaction_id is a sequential identifier.
+	transaction_id INTEGER NOT NULL,
+	-- user_id is the user that made this transaction.
+	user_id INTEGER,
+	-- card_id is the card this transaction was made with, if any.
+	card_id BINARY,
+	-- time is the server-time this transaction happened.
+	time DATETIME,
+	-- amount is the (potentially negative) amount (in cents) of this
+	-- transaction.
+	amount INTEGER,
+	-- kind describes how this transaction was made: via touching an nfc tag to
+	-- the reader or by manually adding an amount in the web-interface.
+	kind TEXT,
+	-- product_id is the product that was bought with this transaction, if any.
+	product_id INTEGER,
+	-- reader is the name of the reader the card was swiped at, if any.
+	reader TEXT,
+	-- reverses is the transaction undone by this one, if any. Only
+	-- transactions of kind 'Storno' reference another one.
+	reverses INTEGER,
+	-- counterpart_id is the other half of a transfer between two users. Only
+	-- transactions of kind 'Überweisung' reference another one.
+	counterpart_id INTEGER,
+
+	-- constraints
+	PRIMARY KEY (transaction_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id),
+	FOREIGN KEY (card_id) REFERENCES cards(card_id),
+	FOREIGN KEY (product_id) REFERENCES products(product_id),
+	FOREIGN KEY (reverses) REFERENCES transactions(transaction_id),
+	FOREIGN KEY (counterpart_id) REFERENCES transactions(transaction_id)
+);
+
+INSERT INTO transactions_new (transaction_id, user_id, card_id, time, amount, kind, product_id, reader, reverses, counterpart_id)
+	SELECT transaction_id, user_id, card_id, time, amount, kind, product_id, reader, reverses, counterpart_id FROM transactions;
+DROP TABLE transactions;
+ALTER TABLE transactions_new RENAME TO transactions;
+`,
+		// On PostgreSQL, card_id has been BYTEA from the start.
+		``},
+	{4, "materialize account balances", `
+-- balance is the sum of the amounts (in cents) of all transactions of this
+-- user. It is updated together with every insert into transactions.
+ALTER TABLE users ADD COLUMN balance INTEGER NOT NULL DEFAULT 0;
+UPDATE users SET balance = (SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE transactions.user_id = users.user_id);
+`, `
+ALTER TABLE users ADD COLUMN balance BIGINT NOT NULL DEFAULT 0;
+UPDATE users SET balance = (SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE transactions.user_id = users.user_id);
+`},
+	{5, "audit log", `
+CREATE TABLE audit_log (
+	-- audit_log contains all changes made through the web interface, the API
+	-- or by treasurers. Entries are never changed or removed, see AuditEntry.
+
+
+	-- entry_id is a sequential identifier.
+	entry_id INTEGER NOT NULL,
+	-- time is the server-time the change was made.
+	time DATETIME,
+	-- actor_id is the user who made the change, if any.
+	actor_id INTEGER,
+	-- action names the kind of change, e.g. 'product.update'.
+	action TEXT NOT NULL,
+	-- subject identifies the changed object, e.g. 'product 3'.
+	subject TEXT NOT NULL,
+	-- old_value and new_value are the JSON-encoded values before and after
+	-- the change.
+	old_value TEXT NOT NULL,
+	new_value TEXT NOT NULL,
+	-- prev_hash is the hash of the previous entry.
+	prev_hash TEXT NOT NULL,
+	-- hash is the hex-encoded SHA-256 of this entry and prev_hash.
+	hash TEXT NOT NULL,
+
+	-- constraints
+	PRIMARY KEY (entry_id),
+	UNIQUE (prev_hash),
+	FOREIGN KEY (actor_id) REFERENCES users(user_id)
+);
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+`, `
+CREATE TABLE audit_log (
+	entry_id SERIAL NOT NULL,
+	time TIMESTAMP WITH TIME ZONE,
+	actor_id INTEGER,
+	action TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	old_value TEXT NOT NULL,
+	new_value TEXT NOT NULL,
+	prev_hash TEXT NOT NULL,
+	hash TEXT NOT NULL,
+
+	-- constraints
+	PRIMARY KEY (entry_id),
+	UNIQUE (prev_hash),
+	FOREIGN KEY (actor_id) REFERENCES users(user_id)
+);
+
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+	FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only();
+`},
+	{6, "inventory", `
+-- stock is the number of units expected to be in store. It is decremented for
+-- every sale and set to the counted number on a stock-take.
+ALTER TABLE products ADD COLUMN stock INTEGER NOT NULL DEFAULT 0;
+-- reorder_level is the stock below which the product should be reordered, if
+-- any.
+ALTER TABLE products ADD COLUMN reorder_level INTEGER;
+
+CREATE TABLE stock_changes (
+	-- stock_changes contains all deliveries and stock-takes of products.
+
+
+	-- change_id is a sequential identifier.
+	change_id INTEGER NOT NULL,
+	-- product_id is the product whose stock changed.
+	product_id INTEGER NOT NULL,
+	-- user_id is the treasurer that recorded the change, if any.
+	user_id INTEGER,
+	-- time is the server-time the change was recorded.
+	time DATETIME,
+	-- kind is either 'delivery' or 'stocktake'.
+	kind TEXT NOT NULL,
+	-- quantity is the number of units delivered or counted.
+	quantity INTEGER NOT NULL,
+	-- expected is the stock of the product before the change.
+	expected INTEGER NOT NULL,
+
+	-- constraints
+	PRIMARY KEY (change_id),
+	FOREIGN KEY (product_id) REFERENCES products(product_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id)
+);
+`, `
+ALTER TABLE products ADD COLUMN stock INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN reorder_level INTEGER;
+
+CREATE TABLE stock_changes (
+	change_id SERIAL NOT NULL,
+	product_id INTEGER NOT NULL,
+	user_id INTEGER,
+	time TIMESTAMP WITH TIME ZONE,
+	kind TEXT NOT NULL,
+	quantity INTEGER NOT NULL,
+	expected INTEGER NOT NULL,
+
+	-- constraints
+	PRIMARY KEY (change_id),
+	FOREIGN KEY (product_id) REFERENCES products(product_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id)
+);
+`},
+	{7, "index transactions by user and time", `
+-- The transaction history of a user is always read newest first.
+CREATE INDEX transactions_user_time ON transactions (user_id, time);
+`, `
+CREATE INDEX transactions_user_time ON transactions (user_id, time);
+`},
+	{8, "undo transactions only once", `
+-- A transaction can be undone only once, even by concurrent undos.
+CREATE UNIQUE INDEX transactions_reverses ON transactions (reverses);
+`, `
+CREATE UNIQUE INDEX transactions_reverses ON transactions (reverses);
+`},
+	{9, "default product", `
+-- Swipes at a reader charge the default product, so there has to be one.
+-- Before the product catalogue, every swipe was charged 100 cents, so that is
+-- what is charged until the treasurers set up the products.
+UPDATE products SET is_default = 1 WHERE name = 'Getränk' AND NOT EXISTS (SELECT 1 FROM products WHERE is_default);
+INSERT INTO products (name, price, is_default) SELECT 'Getränk', 100, 1 WHERE NOT EXISTS (SELECT 1 FROM products WHERE is_default);
+`, `
+UPDATE products SET is_default = TRUE WHERE name = 'Getränk' AND NOT EXISTS (SELECT 1 FROM products WHERE is_default);
+INSERT INTO products (name, price, is_default) SELECT 'Getränk', 100, TRUE WHERE NOT EXISTS (SELECT 1 FROM products WHERE is_default);
+`},
+}
+
+// isPostgres returns whether db is a PostgreSQL database.
+func isPostgres(db *sqlx.DB) bool {
+	return db.DriverName() == "postgres"
+}
+
+// isUniqueViolation returns whether err was caused by a UNIQUE constraint.
+func isUniqueViolation(err error) bool {
+	switch err := err.(type) {
+	case sqlite3.Error:
+		return err.ExtendedCode == sqlite3.ErrConstraintUnique
+	case *pq.Error:
+		return err.Code == "23505"
+	}
+	return false
+}
+
+// statements returns the statements of m for the dialect of db.
+func (m migration) statements(db *sqlx.DB) (string, error) {
+	switch db.DriverName() {
+	case "sqlite3":
+		return m.sqlite, nil
+	case "postgres":
+		return m.postgres, nil
+	default:
+		return "", ErrUnsupportedDriver
+	}
+}
+
+// SchemaVersion returns the version of the newest migration applied to db. It
+// is 0 for an empty database.
+func SchemaVersion(db *sqlx.DB) (int, error) {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL, applied TIMESTAMP, PRIMARY KEY (version))`); err != nil {
+		return 0, err
+	}
+
+	var version int
+	if err := db.Get(&version, `SELECT COALESCE(MAX(version), 0) FROM schema_version`); err != nil {
+		return 0, err
+	}
+	if version != 0 || isPostgres(db) {
+		return version, nil
+	}
+
+	// SQLite databases created by loading schema.sql by hand, before there
+	// were migrations, have either the initial schema or the one of
+	// migration 2.
+	var tables int
+	if err := db.Get(&tables, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'`); err != nil {
+		return 0, err
+	}
+	if tables == 0 {
+		return 0, nil
+	}
+	version = 1
+	var columns int
+	if err := db.Get(&columns, `SELECT COUNT(*) FROM pragma_table_info('transactions') WHERE name = 'counterpart_id'`); err != nil {
+		return 0, err
+	}
+	if columns > 0 {
+		version = 2
+	}
+	if _, err := db.Exec(`INSERT INTO schema_version (version, applied) VALUES ($1, $2)`, version, time.Now()); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Migrate applies all migrations to db, that haven't been applied yet. Every
+// migration is applied in its own database transaction. Only SQLite and
+// PostgreSQL are supported.
+func Migrate(db *sqlx.DB) error {
+	if _, err := migrations[0].statements(db); err != nil {
+		return err
+	}
+
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s): %v", m.version, m.description, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration applies m to db and records it in schema_version.
+func applyMigration(db *sqlx.DB, m migration) error {
+	stmts, err := m.statements(db)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if stmts != "" {
+		if _, err := tx.Exec(stmts); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version, applied) VALUES ($1, $2)`, m.version, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
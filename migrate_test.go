@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	db := createDB(t)
+	defer db.Close()
+
+	version, err := SchemaVersion(db)
+	if err != nil {
+		t.Fatalf("SchemaVersion() = %v", err)
+	}
+	if want := migrations[len(migrations)-1].version; version != want {
+		t.Errorf("SchemaVersion() = %d, want %d", version, want)
+	}
+
+	// Applying migrations again must not change anything.
+	if err := Migrate(db); err != nil {
+		t.Fatalf("second Migrate() = %v", err)
+	}
+	var n int
+	if err := db.Get(&n, `SELECT COUNT(*) FROM schema_version`); err != nil {
+		t.Fatal(err)
+	}
+	if n != len(migrations) {
+		t.Errorf("got %d rows in schema_version, want %d", n, len(migrations))
+	}
+
+	// Swipes charge the default product, so a fresh database needs one.
+	k := Kasse{db: db, log: testLogger(t)}
+	if p, err := k.DefaultProduct(); err != nil || p.Price != 100 {
+		t.Errorf("DefaultProduct() of fresh database = %v, %v, want price 100", p, err)
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	t.Parallel()
+
+	for _, legacy := range []int{1, 2} {
+		db, err := sqlx.Connect("sqlite3", ":memory:")
+		if err != nil {
+			t.Fatalf("could not create in-memory database: %v", err)
+		}
+		defer db.Close()
+
+		// Create the schema by hand, like schema.sql used to be loaded.
+		for _, m := range migrations[:legacy] {
+			if _, err := db.Exec(m.sqlite); err != nil {
+				t.Fatalf("could not load schema of migration %d: %v", m.version, err)
+			}
+		}
+		uid := []byte{0xaa, 0xbb, 0xcc, 0xdd}
+		if _, err := db.Exec(`INSERT INTO users (user_id, name, password) VALUES (1, 'Merovius', '')`); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO cards (card_id, user_id, description) VALUES ($1, 1, '')`, uid); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO transactions (transaction_id, user_id, card_id, time, amount, kind) VALUES (1, 1, $1, $2, 1000, 'Aufladung')`, uid, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		if version, err := SchemaVersion(db); err != nil || version != legacy {
+			t.Fatalf("SchemaVersion() of legacy database = %d, %v, want %d, <nil>", version, err, legacy)
+		}
+		if err := Migrate(db); err != nil {
+			t.Fatalf("Migrate() of legacy database with version %d = %v", legacy, err)
+		}
+
+		k := Kasse{db: db, log: testLogger(t)}
+		balance, err := k.GetBalance(User{ID: 1})
+		if err != nil || balance != 1000 {
+			t.Errorf("GetBalance() after migration from version %d = %d, %v, want 1000, <nil>", legacy, balance, err)
+		}
+		var typ string
+		if err := db.Get(&typ, `SELECT type FROM pragma_table_info('transactions') WHERE name = 'card_id'`); err != nil {
+			t.Fatal(err)
+		}
+		if typ != "BINARY" {
+			t.Errorf("type of transactions.card_id after migration from version %d = %q, want BINARY", legacy, typ)
+		}
+		// Version 1 charged a fixed price, which has to be kept until the
+		// products are set up.
+		p, err := k.DefaultProduct()
+		if err != nil || p.Price != 100 {
+			t.Errorf("DefaultProduct() after migration from version %d = %v, %v, want price 100", legacy, p, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// PairingTimeout gives the time a pairing code stays valid after the unknown
+// card has been swiped.
+var PairingTimeout = 5 * time.Minute
+
+// ErrInvalidPairingCode means that a pairing code was given, that does not
+// exist or has expired.
+var ErrInvalidPairingCode = errors.New("invalid or expired pairing code")
+
+// NewPairingCode creates a one-time code, that can be used with PairCard to
+// register the card with the given UID. Any earlier code for this card is
+// invalidated. The code is valid for PairingTimeout.
+func (k *Kasse) NewPairingCode(uid []byte) (string, error) {
+	k.log.Printf("Creating pairing code for card %x", uid)
+
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if _, err := tx.Exec(`DELETE FROM pairing_codes WHERE card_id = $1 OR created < $2`, uid, now.Add(-PairingTimeout)); err != nil {
+		return "", err
+	}
+
+	var code string
+	for {
+		n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+		if err != nil {
+			return "", err
+		}
+		code = fmt.Sprintf("%06d", n)
+
+		var count int
+		if err := tx.Get(&count, `SELECT COUNT(*) FROM pairing_codes WHERE code = $1`, code); err != nil {
+			return "", err
+		} else if count == 0 {
+			break
+		}
+	}
+
+	if _, err := tx.Exec(`INSERT INTO pairing_codes (code, card_id, created) VALUES ($1, $2, $3)`, code, uid, now); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// PairCard registers the card a pairing code has been created for to owner,
+// using AddCard. The code can only be used once. It returns
+// ErrInvalidPairingCode if the code does not exist or has expired.
+func (k *Kasse) PairCard(code string, owner *User) (*Card, error) {
+	k.log.Printf("Pairing card with code %s for owner %s", code, owner.Name)
+
+	var pairing struct {
+		Card    []byte    `db:"card_id"`
+		Created time.Time `db:"created"`
+	}
+	if err := k.db.Get(&pairing, `SELECT card_id, created FROM pairing_codes WHERE code = $1`, code); err == sql.ErrNoRows {
+		return nil, ErrInvalidPairingCode
+	} else if err != nil {
+		return nil, err
+	}
+
+	if _, err := k.db.Exec(`DELETE FROM pairing_codes WHERE code = $1`, code); err != nil {
+		return nil, err
+	}
+
+	if time.Since(pairing.Created) > PairingTimeout {
+		k.log.Println("Pairing code has expired")
+		return nil, ErrInvalidPairingCode
+	}
+
+	return k.AddCard(pairing.Card, owner)
+}
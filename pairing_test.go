@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPairCard(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	defer k.db.Close()
+
+	mero := &User{ID: 1, Name: "Merovius", Password: []byte("password")}
+	koebi := &User{ID: 2, Name: "Koebi", Password: []byte("password1")}
+	insertData(t, k.db, []User{*mero, *koebi}, []Card{{ID: []byte("baaa"), User: 2}}, nil)
+
+	if _, err := k.PairCard("123456", mero); err != ErrInvalidPairingCode {
+		t.Errorf("PairCard(123456) without swipe == (_, %v), want (_, %v)", err, ErrInvalidPairingCode)
+	}
+
+	old, err := k.NewPairingCode([]byte("aaaa"))
+	if err != nil {
+		t.Fatalf("NewPairingCode(aaaa) == (_, %v), want (_, nil)", err)
+	}
+	code, err := k.NewPairingCode([]byte("aaaa"))
+	if err != nil {
+		t.Fatalf("NewPairingCode(aaaa) == (_, %v), want (_, nil)", err)
+	}
+	if len(code) != 6 {
+		t.Errorf("NewPairingCode(aaaa) == %q, want six digits", code)
+	}
+	if old != code {
+		if _, err := k.PairCard(old, mero); err != ErrInvalidPairingCode {
+			t.Errorf("PairCard(%s) with superseded code == (_, %v), want (_, %v)", old, err, ErrInvalidPairingCode)
+		}
+	}
+
+	card, err := k.PairCard(code, mero)
+	if err != nil {
+		t.Fatalf("PairCard(%s) == (_, %v), want (_, nil)", code, err)
+	}
+	if !bytes.Equal(card.ID, []byte("aaaa")) || card.User != mero.ID {
+		t.Errorf("PairCard(%s) == %v, want card aaaa of Merovius", code, card)
+	}
+	if _, err := k.PairCard(code, koebi); err != ErrInvalidPairingCode {
+		t.Errorf("PairCard(%s) used twice == (_, %v), want (_, %v)", code, err, ErrInvalidPairingCode)
+	}
+
+	code, err = k.NewPairingCode([]byte("baaa"))
+	if err != nil {
+		t.Fatalf("NewPairingCode(baaa) == (_, %v), want (_, nil)", err)
+	}
+	if _, err := k.PairCard(code, mero); err != ErrCardExists {
+		t.Errorf("PairCard(%s) for registered card == (_, %v), want (_, %v)", code, err, ErrCardExists)
+	}
+
+	code, err = k.NewPairingCode([]byte("caaa"))
+	if err != nil {
+		t.Fatalf("NewPairingCode(caaa) == (_, %v), want (_, nil)", err)
+	}
+	if _, err := k.db.Exec(`UPDATE pairing_codes SET created = $1 WHERE code = $2`, time.Now().Add(-PairingTimeout-time.Minute), code); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.PairCard(code, mero); err != ErrInvalidPairingCode {
+		t.Errorf("PairCard(%s) with expired code == (_, %v), want (_, %v)", code, err, ErrInvalidPairingCode)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ResetTimeout gives the time a password reset link stays valid after it has
+// been created.
+var ResetTimeout = 24 * time.Hour
+
+// ErrInvalidResetToken means that a password reset token was given, that does
+// not exist, has already been used or has expired.
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// setPassword sets the password of the user with the given id and returns the
+// new hash. All sessions of the user become invalid, see sessionUser.
+func setPassword(tx execer, id int, password []byte) ([]byte, error) {
+	pwhash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`UPDATE users SET password = $1 WHERE user_id = $2`, pwhash, id); err != nil {
+		return nil, err
+	}
+	return pwhash, nil
+}
+
+// execer is implemented by *sqlx.DB and *sqlx.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// ChangePassword changes the password of user from old to new. It returns
+// ErrWrongAuth if old is not the current password. Wrong passwords count as
+// failed logins of user, like in Login, and ErrTooManyAttempts is returned
+// while they are locked. On success, it returns the updated User.
+func (k *Kasse) ChangePassword(user User, old, new []byte) (*User, error) {
+	k.log.Printf("Changing password of %s", user.Name)
+
+	key := "user:" + user.Name
+	if !k.throttle.attempt(key) {
+		return nil, ErrTooManyAttempts
+	}
+	u, err := k.Authenticate(user.Name, old)
+	if err == ErrWrongAuth {
+		return nil, err
+	} else if err != nil {
+		k.throttle.release(key)
+		return nil, err
+	}
+	k.throttle.clear(key)
+
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Password hashes are not recorded in the audit log.
+	if u.Password, err = setPassword(tx, u.ID, new); err != nil {
+		return nil, err
+	}
+	if err := k.audit(tx, *u, "user.password", fmt.Sprintf("user %d", u.ID), nil, nil); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// NewResetToken creates a one-time token, that can be used with ResetPassword
+// to set a new password for the user with the given id. It returns
+// ErrNotTreasurer if treasurer may not create reset tokens and
+// ErrUserNotFound if there is no such user. The token is valid for
+// ResetTimeout.
+func (k *Kasse) NewResetToken(treasurer User, id int) (string, error) {
+	k.log.Printf("Treasurer %s creates password reset token for user %d", treasurer.Name, id)
+
+	if ok, err := k.IsTreasurer(treasurer); err != nil {
+		return "", err
+	} else if !ok {
+		return "", ErrNotTreasurer
+	}
+
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.Get(&count, `SELECT COUNT(*) FROM users WHERE user_id = $1`, id); err != nil {
+		return "", err
+	} else if count == 0 {
+		return "", ErrUserNotFound
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	if _, err := tx.Exec(`INSERT INTO password_resets (token, user_id, created) VALUES ($1, $2, $3)`, token, id, time.Now()); err != nil {
+		return "", err
+	}
+	// The token itself is not recorded, as it could be used to take over the
+	// account.
+	if err := k.audit(tx, treasurer, "user.reset_link", fmt.Sprintf("user %d", id), nil, nil); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ResetPassword sets the password of the user the reset token belongs to. The
+// token, and all other tokens of that user, can't be used again. It returns
+// ErrInvalidResetToken if the token does not exist or has expired.
+func (k *Kasse) ResetPassword(token string, password []byte) error {
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := tx.Get(&id, `SELECT user_id FROM password_resets WHERE token = $1 AND created >= $2`, token, time.Now().Add(-ResetTimeout)); err == sql.ErrNoRows {
+		return ErrInvalidResetToken
+	} else if err != nil {
+		return err
+	}
+	k.log.Printf("Resetting password of user %d", id)
+
+	if _, err := tx.Exec(`DELETE FROM password_resets WHERE user_id = $1`, id); err != nil {
+		return err
+	}
+	if _, err := setPassword(tx, id, password); err != nil {
+		return err
+	}
+	if err := k.audit(tx, User{}, "user.password_reset", fmt.Sprintf("user %d", id), nil, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestChangePassword(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	defer k.db.Close()
+
+	mero, err := k.RegisterUser("Merovius", []byte("foobar"))
+	if err != nil {
+		t.Fatalf("RegisterUser(Merovius) == (_, %v), want (_, nil)", err)
+	}
+
+	now := time.Now()
+	k.throttle.now = func() time.Time { return now }
+
+	for i := 0; i <= LoginFreeAttempts; i++ {
+		if _, err := k.ChangePassword(*mero, []byte("foobaz"), []byte("new")); err != ErrWrongAuth {
+			t.Errorf("ChangePassword with wrong password == (_, %v), want (_, %v)", err, ErrWrongAuth)
+		}
+	}
+	// Guessing the old password is throttled like logins.
+	if _, err := k.ChangePassword(*mero, []byte("foobar"), []byte("new")); err != ErrTooManyAttempts {
+		t.Errorf("ChangePassword after failures == (_, %v), want (_, %v)", err, ErrTooManyAttempts)
+	}
+	now = now.Add(LoginBackoff)
+	if _, err := k.ChangePassword(*mero, []byte("foobar"), []byte("new")); err != nil {
+		t.Errorf("ChangePassword == (_, %v), want (_, nil)", err)
+	}
+	if _, err := k.Authenticate("Merovius", []byte("foobar")); err != ErrWrongAuth {
+		t.Errorf("Authenticate with old password == (_, %v), want (_, %v)", err, ErrWrongAuth)
+	}
+	if _, err := k.Authenticate("Merovius", []byte("new")); err != nil {
+		t.Errorf("Authenticate with new password == (_, %v), want (_, nil)", err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	defer k.db.Close()
+
+	mero, err := k.RegisterUser("Merovius", []byte("foobar"))
+	if err != nil {
+		t.Fatalf("RegisterUser(Merovius) == (_, %v), want (_, nil)", err)
+	}
+	koebi, err := k.RegisterUser("Koebi", []byte("password"))
+	if err != nil {
+		t.Fatalf("RegisterUser(Koebi) == (_, %v), want (_, nil)", err)
+	}
+	if _, err := k.db.Exec(`UPDATE users SET treasurer = TRUE WHERE user_id = $1`, mero.ID); err != nil {
+		t.Fatalf("could not make %v a treasurer: %v", mero.Name, err)
+	}
+
+	if _, err := k.NewResetToken(*koebi, mero.ID); err != ErrNotTreasurer {
+		t.Errorf("NewResetToken by non-treasurer == (_, %v), want (_, %v)", err, ErrNotTreasurer)
+	}
+	if _, err := k.NewResetToken(*mero, 23); err != ErrUserNotFound {
+		t.Errorf("NewResetToken(23) == (_, %v), want (_, %v)", err, ErrUserNotFound)
+	}
+
+	if _, err := k.db.Exec(`INSERT INTO password_resets (token, user_id, created) VALUES ($1, $2, $3)`, "expired", koebi.ID, time.Now().Add(-2*ResetTimeout)); err != nil {
+		t.Fatalf("could not insert expired token: %v", err)
+	}
+	if err := k.ResetPassword("expired", []byte("new")); err != ErrInvalidResetToken {
+		t.Errorf("ResetPassword(expired) == %v, want %v", err, ErrInvalidResetToken)
+	}
+
+	token, err := k.NewResetToken(*mero, koebi.ID)
+	if err != nil {
+		t.Fatalf("NewResetToken(Koebi) == (_, %v), want (_, nil)", err)
+	}
+	if err := k.ResetPassword(token, []byte("new")); err != nil {
+		t.Errorf("ResetPassword == %v, want nil", err)
+	}
+	if err := k.ResetPassword(token, []byte("newer")); err != ErrInvalidResetToken {
+		t.Errorf("ResetPassword with used token == %v, want %v", err, ErrInvalidResetToken)
+	}
+	if _, err := k.Authenticate("Koebi", []byte("new")); err != nil {
+		t.Errorf("Authenticate with new password == (_, %v), want (_, nil)", err)
+	}
+}
+
+func TestPasswordInvalidatesSessions(t *testing.T) {
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	k.sessions = sessions.NewCookieStore([]byte("foobar"))
+	h := k.Handler()
+
+	if _, err := k.RegisterUser("Merovius", []byte("foobar")); err != nil {
+		t.Fatalf("RegisterUser(Merovius) == (_, %v), want (_, nil)", err)
+	}
+
+	tokens := make(map[http.CookieJar]string)
+	do := func(jar http.CookieJar, method, u string, form url.Values) int {
+		if form != nil {
+			form = withCSRF(form, tokens[jar])
+		}
+		req := httptest.NewRequest(method, u, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range jar.Cookies(req.URL) {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		jar.SetCookies(req.URL, createResponse(req, rec).Cookies())
+		if tok := csrfTokenOf(rec.Body.String()); tok != "" {
+			tokens[jar] = tok
+		}
+		return rec.Code
+	}
+
+	login := url.Values{"username": {"Merovius"}, "password": {"foobar"}}
+	jar1, _ := cookiejar.New(nil)
+	jar2, _ := cookiejar.New(nil)
+	for _, jar := range []http.CookieJar{jar1, jar2} {
+		do(jar, "GET", "http://localhost:9000/login.html", nil)
+		if c := do(jar, "POST", "http://localhost:9000/login.html", login); c != http.StatusFound {
+			t.Fatalf("POST /login.html has code %d, expected %d", c, http.StatusFound)
+		}
+	}
+
+	change := url.Values{"old": {"foobar"}, "password": {"new"}, "confirm": {"new"}}
+	if c := do(jar1, "POST", "http://localhost:9000/password.html", change); c != http.StatusFound {
+		t.Fatalf("POST /password.html has code %d, expected %d", c, http.StatusFound)
+	}
+
+	if c := do(jar1, "GET", "http://localhost:9000/", nil); c != http.StatusOK {
+		t.Errorf("GET / in session that changed the password has code %d, expected %d", c, http.StatusOK)
+	}
+	if c := do(jar2, "GET", "http://localhost:9000/", nil); c != http.StatusFound {
+		t.Errorf("GET / in other session has code %d, expected %d", c, http.StatusFound)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Product represents a product that can be bought by swiping a card (as in
+// the database schema).
+type Product struct {
+	ID      int    `db:"product_id"`
+	Name    string `db:"name"`
+	Price   int    `db:"price"`
+	Default bool   `db:"is_default"`
+	// Stock is the number of units expected to be in store. It is decremented
+	// for every sale and may become negative, if a delivery wasn't recorded.
+	Stock int `db:"stock"`
+	// ReorderLevel is the stock below which the product should be reordered.
+	// If it is not valid, the product is never flagged.
+	ReorderLevel sql.NullInt64 `db:"reorder_level"`
+}
+
+// ErrProductNotFound means that there is no product with the given id, or no
+// default product if none was given.
+var ErrProductNotFound = errors.New("product not found")
+
+// ErrProductExists means that a product with a duplicate name was tried to
+// add.
+var ErrProductExists = errors.New("product already exists")
+
+// ErrDefaultProduct means that the default product was tried to delete.
+// Another product has to be made the default first, so swipes can still be
+// charged.
+var ErrDefaultProduct = errors.New("the default product can't be deleted")
+
+// ErrProductInUse means that a product was tried to delete, that is still
+// referenced by transactions or stock changes.
+var ErrProductInUse = errors.New("product is referenced by transactions")
+
+// AddProduct adds a product with the given name and price (in cents) to the
+// catalogue on behalf of actor. The first product added becomes the default
+// product. It returns ErrProductExists if a product with that name already
+// exists.
+func (k *Kasse) AddProduct(actor User, name string, price int) (*Product, error) {
+	k.log.Printf("Adding product %s for %d", name, price)
+
+	if price < 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// We need to check first if the name is already taken, because the error
+	// from an insert can't be checked programmatically.
+	var n int
+	if err := tx.Get(&n, `SELECT COUNT(*) FROM products WHERE name = $1`, name); err != nil {
+		return nil, err
+	} else if n > 0 {
+		return nil, ErrProductExists
+	}
+
+	if err := tx.Get(&n, `SELECT COUNT(*) FROM products`); err != nil {
+		return nil, err
+	}
+
+	p := &Product{Name: name, Price: price, Default: n == 0}
+	if err := tx.Get(&p.ID, `INSERT INTO products (name, price, is_default) VALUES ($1, $2, $3) RETURNING product_id`, p.Name, p.Price, p.Default); err != nil {
+		return nil, err
+	}
+	if err := k.audit(tx, actor, "product.add", fmt.Sprintf("product %d", p.ID), nil, p); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetProducts gets all products, ordered by name.
+func (k *Kasse) GetProducts() ([]Product, error) {
+	var products []Product
+	if err := k.db.Select(&products, `SELECT product_id, name, price, is_default, stock, reorder_level FROM products ORDER BY name`); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// GetProduct gets the product with the given id. It returns
+// ErrProductNotFound if there is none.
+func (k *Kasse) GetProduct(id int) (*Product, error) {
+	p := new(Product)
+	if err := k.db.Get(p, `SELECT product_id, name, price, is_default, stock, reorder_level FROM products WHERE product_id = $1`, id); err == sql.ErrNoRows {
+		return nil, ErrProductNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// DefaultProduct gets the product charged for a swipe, if no other product
+// was picked. It returns ErrProductNotFound if there is none.
+func (k *Kasse) DefaultProduct() (*Product, error) {
+	p := new(Product)
+	if err := k.db.Get(p, `SELECT product_id, name, price, is_default, stock, reorder_level FROM products WHERE is_default`); err == sql.ErrNoRows {
+		return nil, ErrProductNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// getProduct gets the product with the given id in tx. It returns
+// ErrProductNotFound if there is none.
+func getProduct(tx *sqlx.Tx, id int) (*Product, error) {
+	p := new(Product)
+	if err := tx.Get(p, `SELECT product_id, name, price, is_default, stock, reorder_level FROM products WHERE product_id = $1`, id); err == sql.ErrNoRows {
+		return nil, ErrProductNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// UpdateProduct changes name and price of the product with ID p.ID on behalf
+// of actor. It returns ErrProductNotFound if there is no such product and
+// ErrProductExists if another product already has that name.
+func (k *Kasse) UpdateProduct(actor User, p Product) error {
+	k.log.Printf("Updating product %d to %s for %d", p.ID, p.Name, p.Price)
+
+	if p.Price < 0 {
+		return ErrInvalidAmount
+	}
+
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	old, err := getProduct(tx, p.ID)
+	if err != nil {
+		return err
+	}
+
+	var n int
+	if err := tx.Get(&n, `SELECT COUNT(*) FROM products WHERE name = $1 AND product_id != $2`, p.Name, p.ID); err != nil {
+		return err
+	} else if n > 0 {
+		return ErrProductExists
+	}
+
+	if _, err := tx.Exec(`UPDATE products SET name = $1, price = $2 WHERE product_id = $3`, p.Name, p.Price, p.ID); err != nil {
+		return err
+	}
+	p.Default, p.Stock, p.ReorderLevel = old.Default, old.Stock, old.ReorderLevel
+	if err := k.audit(tx, actor, "product.update", fmt.Sprintf("product %d", p.ID), old, p); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetDefaultProduct makes the product with the given id the one charged for a
+// swipe, if no other product was picked, on behalf of actor.
+func (k *Kasse) SetDefaultProduct(actor User, id int) error {
+	k.log.Printf("Setting default product to %d", id)
+
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := getProduct(tx, id); err != nil {
+		return err
+	}
+	var prev interface{}
+	var prevID int
+	if err := tx.Get(&prevID, `SELECT product_id FROM products WHERE is_default`); err == nil {
+		prev = prevID
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE products SET is_default = $1 WHERE is_default`, false); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE products SET is_default = $1 WHERE product_id = $2`, true, id); err != nil {
+		return err
+	}
+	if err := k.audit(tx, actor, "product.default", "default product", prev, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteProduct removes the product with the given id from the catalogue on
+// behalf of actor. Products that have already been sold or stocked can't be
+// deleted, to keep the history intact; in that case ErrProductInUse is
+// returned. The default product can't be deleted either, see
+// ErrDefaultProduct.
+func (k *Kasse) DeleteProduct(actor User, id int) error {
+	k.log.Printf("Deleting product %d", id)
+
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err := tx.Get(&n, `SELECT COUNT(*) FROM transactions WHERE product_id = $1`, id); err != nil {
+		return err
+	} else if n > 0 {
+		return ErrProductInUse
+	}
+	if err := tx.Get(&n, `SELECT COUNT(*) FROM stock_changes WHERE product_id = $1`, id); err != nil {
+		return err
+	} else if n > 0 {
+		return ErrProductInUse
+	}
+
+	old, err := getProduct(tx, id)
+	if err != nil {
+		return err
+	}
+	if old.Default {
+		return ErrDefaultProduct
+	}
+	if _, err := tx.Exec(`DELETE FROM products WHERE product_id = $1`, id); err != nil {
+		return err
+	}
+	if err := k.audit(tx, actor, "product.delete", fmt.Sprintf("product %d", id), old, nil); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProducts(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	defer k.db.Close()
+
+	// The database comes with a default product.
+	if _, err := k.DefaultProduct(); err != nil {
+		t.Errorf("DefaultProduct() == (_, %v), want (_, nil)", err)
+	}
+
+	mate, err := k.AddProduct(User{}, "Mate", 100)
+	if err != nil {
+		t.Fatalf("AddProduct(Mate, 100) == (_, %v), want (_, nil)", err)
+	}
+	if mate.Default {
+		t.Errorf("new product %v replaced the default", mate)
+	}
+	beer, err := k.AddProduct(User{}, "Bier", 150)
+	if err != nil {
+		t.Fatalf("AddProduct(Bier, 150) == (_, %v), want (_, nil)", err)
+	}
+	if beer.Default {
+		t.Errorf("second product %v is the default", beer)
+	}
+
+	if _, err := k.AddProduct(User{}, "Mate", 200); err != ErrProductExists {
+		t.Errorf("AddProduct(Mate, 200) == (_, %v), want (_, %v)", err, ErrProductExists)
+	}
+	if _, err := k.AddProduct(User{}, "Wasser", -1); err != ErrInvalidAmount {
+		t.Errorf("AddProduct(Wasser, -1) == (_, %v), want (_, %v)", err, ErrInvalidAmount)
+	}
+
+	if err := k.UpdateProduct(User{}, Product{ID: beer.ID, Name: "Mate", Price: 150}); err != ErrProductExists {
+		t.Errorf("renaming Bier to Mate returned %v, want %v", err, ErrProductExists)
+	}
+	if err := k.UpdateProduct(User{}, Product{ID: beer.ID, Name: "Pils", Price: 180}); err != nil {
+		t.Errorf("UpdateProduct(Pils) == %v, want nil", err)
+	}
+	if p, err := k.GetProduct(beer.ID); err != nil || p.Name != "Pils" || p.Price != 180 {
+		t.Errorf("GetProduct(%d) == (%v, %v), want Pils for 180", beer.ID, p, err)
+	}
+	if err := k.UpdateProduct(User{}, Product{ID: 23, Name: "Kaffee", Price: 50}); err != ErrProductNotFound {
+		t.Errorf("UpdateProduct(23) == %v, want %v", err, ErrProductNotFound)
+	}
+
+	if err := k.SetDefaultProduct(User{}, beer.ID); err != nil {
+		t.Errorf("SetDefaultProduct(%d) == %v, want nil", beer.ID, err)
+	}
+	if p, err := k.DefaultProduct(); err != nil || p.ID != beer.ID {
+		t.Errorf("DefaultProduct() == (%v, %v), want %v", p, err, beer.ID)
+	}
+	if err := k.SetDefaultProduct(User{}, 23); err != ErrProductNotFound {
+		t.Errorf("SetDefaultProduct(23) == %v, want %v", err, ErrProductNotFound)
+	}
+
+	if ps, err := k.GetProducts(); err != nil || len(ps) != 3 {
+		t.Errorf("GetProducts() == (%v, %v), want 3 products", ps, err)
+	}
+
+	insertData(t, k.db, []User{{ID: 1, Name: "Merovius"}}, []Card{{ID: []byte("aaaa"), User: 1}}, []Transaction{
+		{ID: 1, User: 1, Time: time.Now(), Amount: 1000, Kind: "Aufladung"},
+	})
+	if _, err := k.HandleCard("", []byte("aaaa"), mate); err != nil {
+		t.Fatalf("HandleCard(aaaa, Mate) == (_, %v), want (_, nil)", err)
+	}
+
+	if err := k.DeleteProduct(User{}, mate.ID); err != ErrProductInUse {
+		t.Errorf("DeleteProduct(Mate) == %v, want %v", err, ErrProductInUse)
+	}
+	if err := k.DeleteProduct(User{}, beer.ID); err != ErrDefaultProduct {
+		t.Errorf("DeleteProduct(Pils) of default == %v, want %v", err, ErrDefaultProduct)
+	}
+	if err := k.SetDefaultProduct(User{}, mate.ID); err != nil {
+		t.Fatalf("SetDefaultProduct(Mate) == %v, want nil", err)
+	}
+	if err := k.DeleteProduct(User{}, beer.ID); err != nil {
+		t.Errorf("DeleteProduct(Pils) == %v, want nil", err)
+	}
+	if err := k.DeleteProduct(User{}, beer.ID); err != ErrProductNotFound {
+		t.Errorf("DeleteProduct(Pils) == %v, want %v", err, ErrProductNotFound)
+	}
+}
+
+func TestHandleCardProduct(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	defer k.db.Close()
+
+	mero := User{ID: 1, Name: "Merovius", Password: []byte("password")}
+	insertData(t, k.db, []User{mero}, []Card{{ID: []byte("aaaa"), User: 1}}, []Transaction{
+		{ID: 1, User: 1, Time: time.Now(), Amount: 1000, Kind: "Aufladung"},
+	})
+
+	mate, err := k.AddProduct(User{}, "Mate", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	beer, err := k.AddProduct(User{}, "Bier", 450)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetDefaultProduct(User{}, mate.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tcs := []struct {
+		product *Product
+		wantErr error
+		want    ResultCode
+		balance int64
+	}{
+		{nil, nil, PaymentMade, 900},
+		{beer, nil, LowBalance, 450},
+		{beer, nil, LowBalance, 0},
+		{mate, ErrAccountEmpty, AccountEmpty, 0},
+	}
+
+	for _, tc := range tcs {
+		got, gotErr := k.HandleCard("", []byte("aaaa"), tc.product)
+		if gotErr != tc.wantErr || got == nil || got.Code != tc.want {
+			t.Errorf("HandleCard(aaaa, %v) == (%v, %v), want (%v, %v)", tc.product, got, gotErr, tc.want, tc.wantErr)
+		}
+		if got != nil && (got.Account != float32(tc.balance)/100 || got.Balance != tc.balance) {
+			t.Errorf("HandleCard(aaaa, %v) reports balance (%v, %v), want (%v, %v)", tc.product, got.Account, got.Balance, float32(tc.balance)/100, tc.balance)
+		}
+		if b, err := k.GetBalance(mero); err != nil || b != tc.balance {
+			t.Errorf("GetBalance(%v) == (%v, %v), want (%v, nil)", mero.Name, b, err, tc.balance)
+		}
+	}
+
+	ts, err := k.GetTransactions(mero, 1)
+	if err != nil || len(ts) != 1 {
+		t.Fatalf("GetTransactions(%v, 1) == (%v, %v), want one transaction", mero.Name, ts, err)
+	}
+	if !ts[0].ProductName.Valid || ts[0].ProductName.String != "Bier" {
+		t.Errorf("last transaction has product %v, want Bier", ts[0].ProductName)
+	}
+}
+
+func TestHandleCardReaderProduct(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	defer k.db.Close()
+
+	mero := User{ID: 1, Name: "Merovius", Password: []byte("password")}
+	insertData(t, k.db, []User{mero}, []Card{{ID: []byte("aaaa"), User: 1}}, []Transaction{
+		{ID: 1, User: 1, Time: time.Now(), Amount: 1000, Kind: "Aufladung"},
+	})
+
+	mate, err := k.AddProduct(User{}, "Mate", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	beer, err := k.AddProduct(User{}, "Bier", 450)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetDefaultProduct(User{}, mate.ID); err != nil {
+		t.Fatal(err)
+	}
+	k.readerProducts = map[string]int{"bar": beer.ID, "gone": 23}
+
+	tcs := []struct {
+		reader  string
+		wantErr error
+		product string
+	}{
+		{"bar", nil, "Bier"},
+		{"front", nil, "Mate"},
+		{"gone", ErrProductNotFound, ""},
+	}
+	for _, tc := range tcs {
+		got, err := k.HandleCard(tc.reader, []byte("aaaa"), nil)
+		if err != tc.wantErr {
+			t.Errorf("HandleCard(%q, aaaa) == (_, %v), want (_, %v)", tc.reader, err, tc.wantErr)
+			continue
+		}
+		if err == nil && got.Product != tc.product {
+			t.Errorf("HandleCard(%q, aaaa) charged %q, want %q", tc.reader, got.Product, tc.product)
+		}
+	}
+}
@@ -89,19 +89,34 @@ func pollNFC(d nfc.Device, m nfc.Modulation) (uid []byte, err error) {
 	return tt.UID[:tt.UIDLen], nil
 }
 
-// ConnectAndPollNFCReader connects to a physical NFC Reader and pools for new
-// cards. conn is the reader to connect to - if empty, the first available
+// NFCReader is a Reader for a physical NFC reader, using libnfc.
+type NFCReader struct {
+	d   nfc.Device
+	mod nfc.Modulation
+}
+
+// OpenNFCReader connects to a physical NFC Reader. conn is the libnfc
+// connstring of the reader to connect to - if empty, the first available
 // reader will be used.
-func ConnectAndPollNFCReader(conn string, ch chan NFCEvent) error {
+func OpenNFCReader(conn string) (*NFCReader, error) {
 	if DefaultModulation.Type != nfc.ISO14443a {
-		return errors.New("only ISO 14443-A readers are supported for now")
+		return nil, errors.New("only ISO 14443-A readers are supported for now")
 	}
 
 	d, err := nfc.Open(conn)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	r := &NFCReader{d: d}
+	if err := r.init(); err != nil {
+		d.Close()
+		return nil, err
 	}
-	defer d.Close()
+	return r, nil
+}
+
+func (r *NFCReader) init() error {
+	d := r.d
 
 	log.Printf("NFC reader information:\n%s\n", d)
 
@@ -143,15 +158,24 @@ func ConnectAndPollNFCReader(conn string, ch chan NFCEvent) error {
 		return err
 	}
 
-	mod := nfc.Modulation{Type: m, BaudRate: b}
+	r.mod = nfc.Modulation{Type: m, BaudRate: b}
+	return nil
+}
 
-	// start polling
+// GetNextUID implements Reader. It polls the reader every PollingInterval. A
+// card is reported on every poll for as long as it stays in the field, see
+// Debounce.
+func (r *NFCReader) GetNextUID() ([]byte, error) {
 	for {
-		uid, err := pollNFC(d, mod)
-		if uid == nil && err == nil {
-			time.Sleep(PollingInterval)
-			continue
+		time.Sleep(PollingInterval)
+		uid, err := pollNFC(r.d, r.mod)
+		if uid != nil || err != nil {
+			return uid, err
 		}
-		ch <- NFCEvent{uid, err}
 	}
 }
+
+// Close implements Reader.
+func (r *NFCReader) Close() error {
+	return r.d.Close()
+}
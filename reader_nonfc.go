@@ -2,10 +2,23 @@
 
 package main
 
-// ConnectAndPollNFCReader is a stub to enable a build without libnfc. It
-// blocks indefinitely.
-func ConnectAndPollNFCReader(conn string, ch chan NFCEvent) error {
-	block := make(chan bool)
-	<-block
+import "errors"
+
+// NFCReader is a stub to enable a build without libnfc.
+type NFCReader struct{}
+
+// OpenNFCReader is a stub to enable a build without libnfc. It always returns
+// an error.
+func OpenNFCReader(conn string) (*NFCReader, error) {
+	return nil, errors.New("kasse was built without libnfc support")
+}
+
+// GetNextUID implements Reader.
+func (r *NFCReader) GetNextUID() ([]byte, error) {
+	return nil, errors.New("kasse was built without libnfc support")
+}
+
+// Close implements Reader.
+func (r *NFCReader) Close() error {
 	return nil
 }
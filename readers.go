@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Reader is a source of swiped cards.
+type Reader interface {
+	// GetNextUID blocks until a card is in the field of the reader and returns
+	// its UID. A card that stays in the field is returned again on every call.
+	// It returns io.EOF if the reader will never return another UID.
+	GetNextUID() ([]byte, error)
+	// Close releases all resources of the reader.
+	Close() error
+}
+
+// PollReader reads UIDs from r and sends them as NFCEvents for the reader name
+// to ch. Errors are sent to ch as well. When r returns io.EOF, it is closed
+// and PollReader returns.
+func PollReader(name string, r Reader, ch chan<- NFCEvent) error {
+	for {
+		uid, err := r.GetNextUID()
+		if err == io.EOF {
+			return r.Close()
+		}
+		ch <- NFCEvent{Reader: name, UID: uid, Err: err}
+	}
+}
+
+// readerFlag is a flag.Value collecting a setting per reader, given as
+// name=value.
+type readerFlag map[string]string
+
+// String implements flag.Value.
+func (f readerFlag) String() string {
+	var s []string
+	for name, val := range f {
+		s = append(s, name+"="+val)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+// Set implements flag.Value.
+func (f readerFlag) Set(v string) error {
+	i := strings.IndexByte(v, '=')
+	if i <= 0 {
+		return fmt.Errorf("reader %q is not of the form name=value", v)
+	}
+	name, val := v[:i], v[i+1:]
+	if _, ok := f[name]; ok {
+		return fmt.Errorf("reader %q given twice", name)
+	}
+	f[name] = val
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestPollReader(t *testing.T) {
+	t.Parallel()
+
+	errRead := errors.New("read error")
+	reader := TestReader{
+		{UID: []byte("aaaa")},
+		{Err: errRead},
+		{UID: []byte("baaa")},
+	}
+
+	ch := make(chan NFCEvent)
+	done := make(chan error)
+	go func() {
+		done <- PollReader("front", &reader, ch)
+	}()
+
+	want := []NFCEvent{
+		{Reader: "front", UID: []byte("aaaa")},
+		{Reader: "front", Err: errRead},
+		{Reader: "front", UID: []byte("baaa")},
+	}
+	for _, w := range want {
+		got := <-ch
+		if got.Reader != w.Reader || !bytes.Equal(got.UID, w.UID) || got.Err != w.Err {
+			t.Errorf("PollReader sent %v, want %v", got, w)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Errorf("PollReader(…) == %v, want nil", err)
+	}
+}
+
+func TestReaderFlag(t *testing.T) {
+	t.Parallel()
+
+	f := make(readerFlag)
+	if err := f.Set("front=pn532_uart:/dev/ttyUSB0"); err != nil {
+		t.Errorf("Set(front=…) == %v, want nil", err)
+	}
+	if err := f.Set("back="); err != nil {
+		t.Errorf("Set(back=) == %v, want nil", err)
+	}
+	if err := f.Set("front=acr122_usb"); err == nil {
+		t.Errorf("Set of duplicate reader front == nil, want error")
+	}
+	for _, v := range []string{"", "front", "=acr122_usb"} {
+		if err := f.Set(v); err == nil {
+			t.Errorf("Set(%q) == nil, want error", v)
+		}
+	}
+	if got, want := f.String(), "back=,front=pn532_uart:/dev/ttyUSB0"; got != want {
+		t.Errorf("String() == %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// SessionKeysEnv is the environment variable, that session keys are read from
+// if no key file is given.
+const SessionKeysEnv = "KASSE_SESSION_KEYS"
+
+// SessionConfig configures the cookie store used for sessions.
+type SessionConfig struct {
+	// Keys are pairs of signing and encryption keys, as expected by
+	// sessions.NewCookieStore. The first pair is used for new cookies, all
+	// pairs are tried when decoding, so keys can be rotated.
+	Keys [][]byte
+	// MaxAge is how long a session stays valid.
+	MaxAge time.Duration
+	// Secure restricts the cookie to HTTPS connections.
+	Secure bool
+	// SameSite is the SameSite attribute of the cookie.
+	SameSite http.SameSite
+}
+
+// Validate checks that browsers accept the cookies configured by c. They
+// reject cookies with SameSite=None, that are not Secure, so nobody could log
+// in.
+func (c SessionConfig) Validate() error {
+	if c.SameSite == http.SameSiteNoneMode && !c.Secure {
+		return fmt.Errorf("session cookies with SameSite=None have to be Secure")
+	}
+	return nil
+}
+
+// ParseSessionKeys parses session key pairs from r. Every line contains a
+// hex-encoded signing key of 32 or 64 bytes and a hex-encoded encryption key
+// of 16, 24 or 32 bytes, separated by whitespace. The first line is the
+// current key pair, the others are only used to decode existing cookies. Empty
+// lines and lines starting with '#' are ignored.
+func ParseSessionKeys(r io.Reader) ([][]byte, error) {
+	var keys [][]byte
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: want signing and encryption key", n)
+		}
+		hash, err := hex.DecodeString(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid signing key: %v", n, err)
+		}
+		if len(hash) != 32 && len(hash) != 64 {
+			return nil, fmt.Errorf("line %d: signing key must be 32 or 64 bytes", n)
+		}
+		block, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid encryption key: %v", n, err)
+		}
+		if len(block) != 16 && len(block) != 24 && len(block) != 32 {
+			return nil, fmt.Errorf("line %d: encryption key must be 16, 24 or 32 bytes", n)
+		}
+		keys = append(keys, hash, block)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no session keys given")
+	}
+	return keys, nil
+}
+
+// LoadSessionKeys reads session keys from file or, if file is empty, from the
+// environment variable SessionKeysEnv. If neither is set, it generates a
+// random key pair, so sessions don't survive a restart.
+func LoadSessionKeys(file string) ([][]byte, error) {
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return ParseSessionKeys(f)
+	}
+	if v := os.Getenv(SessionKeysEnv); v != "" {
+		return ParseSessionKeys(strings.NewReader(strings.Replace(v, ",", "\n", -1)))
+	}
+
+	hash, block := make([]byte, 32), make([]byte, 32)
+	if _, err := rand.Read(hash); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(block); err != nil {
+		return nil, err
+	}
+	return [][]byte{hash, block}, nil
+}
+
+// parseSameSite parses the value of the SameSite cookie attribute.
+func parseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(s) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("invalid SameSite value %q", s)
+	}
+}
+
+// NewSessionStore returns a cookie store for sessions configured by c. Cookies
+// are signed, encrypted and not accessible to JavaScript.
+func NewSessionStore(c SessionConfig) *sessions.CookieStore {
+	store := sessions.NewCookieStore(c.Keys...)
+	store.Options = &sessions.Options{
+		Path:     "/",
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: c.SameSite,
+	}
+	store.MaxAge(int(c.MaxAge / time.Second))
+	return store
+}
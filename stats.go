@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// countedSwipes selects the swipes of the transactions t, that have not been
+// undone. Only those are counted in statistics.
+const countedSwipes = `t.kind = 'Kartenswipe' AND NOT EXISTS (SELECT 1 FROM transactions r WHERE r.reverses = t.transaction_id)`
+
+// Spending is the amount (in cents) spent on swipes in one month.
+type Spending struct {
+	// Month is the local midnight of the first day of the month.
+	Month  time.Time
+	Amount int64
+}
+
+// GetMonthlySpending gets what user spent on swipes in each of the last months
+// months, including the current one, oldest first. Months are bucketed in
+// local time, which SQLite and PostgreSQL don't agree on, so it is done here
+// instead of in the query.
+func (k *Kasse) GetMonthlySpending(user User, months int) ([]Spending, error) {
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month()-time.Month(months-1), 1, 0, 0, 0, 0, time.Local)
+
+	spending := make([]Spending, months)
+	for i := range spending {
+		spending[i].Month = start.AddDate(0, i, 0)
+	}
+
+	var ts []Transaction
+	if err := k.db.Select(&ts, `SELECT t.time, t.amount FROM transactions t WHERE t.user_id = $1 AND t.time >= $2 AND `+countedSwipes, user.ID, start); err != nil {
+		return nil, err
+	}
+	for _, t := range ts {
+		tm := t.Time.Local()
+		i := (tm.Year()-start.Year())*12 + int(tm.Month()-start.Month())
+		if i < 0 || i >= months {
+			continue
+		}
+		spending[i].Amount -= int64(t.Amount)
+	}
+	return spending, nil
+}
+
+// ProductSales is how often and for how much (in cents) a product was sold.
+type ProductSales struct {
+	Name   string `db:"name"`
+	Count  int    `db:"count"`
+	Amount int64  `db:"amount"`
+}
+
+// GetTopProducts gets the products sold most often since the given time, at
+// most limit of them. If user is not zero, only the purchases of the user with
+// that id are counted.
+func (k *Kasse) GetTopProducts(user int, since time.Time, limit int) ([]ProductSales, error) {
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	where := "t.time >= " + arg(since) + " AND " + countedSwipes
+	if user != 0 {
+		where += " AND t.user_id = " + arg(user)
+	}
+
+	var sales []ProductSales
+	if err := k.db.Select(&sales, `SELECT p.name, COUNT(*) AS count, -SUM(t.amount) AS amount
+		FROM transactions t JOIN products p ON t.product_id = p.product_id
+		WHERE `+where+`
+		GROUP BY p.product_id, p.name
+		ORDER BY count DESC, p.name
+		LIMIT `+arg(limit), args...); err != nil {
+		return nil, err
+	}
+	return sales, nil
+}
+
+// SwipeTimes counts swipes by the local hour of the day and the weekday they
+// were made at.
+type SwipeTimes struct {
+	Hour [24]int
+	// Weekday is indexed by time.Weekday, i.e. starts on Sunday.
+	Weekday [7]int
+}
+
+// GetSwipeTimes counts the swipes of all users since the given time by hour
+// and weekday. Like in GetMonthlySpending, they are bucketed here in local
+// time.
+func (k *Kasse) GetSwipeTimes(since time.Time) (*SwipeTimes, error) {
+	rows, err := k.db.Query(`SELECT t.time FROM transactions t WHERE t.time >= $1 AND `+countedSwipes, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	st := new(SwipeTimes)
+	for rows.Next() {
+		var tm time.Time
+		if err := rows.Scan(&tm); err != nil {
+			return nil, err
+		}
+		tm = tm.Local()
+		st.Hour[tm.Hour()]++
+		st.Weekday[tm.Weekday()]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
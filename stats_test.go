@@ -0,0 +1,104 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	defer k.db.Close()
+
+	for _, p := range []Product{
+		{ID: 2, Name: "Mate", Price: 100},
+		{ID: 3, Name: "Bier", Price: 150},
+	} {
+		if _, err := k.db.Exec(`INSERT INTO products (product_id, name, price, is_default) VALUES ($1, $2, $3, $4)`, p.ID, p.Name, p.Price, p.Default); err != nil {
+			t.Fatalf("could not insert product %v: %v", p, err)
+		}
+	}
+	mate := sql.NullInt64{Int64: 2, Valid: true}
+	beer := sql.NullInt64{Int64: 3, Valid: true}
+
+	now := time.Now()
+	this := time.Date(now.Year(), now.Month(), 1, 20, 0, 0, 0, time.Local)
+	last := this.AddDate(0, -1, 0)
+	old := this.AddDate(-2, 0, 0)
+
+	insertData(t, k.db, []User{
+		{ID: 1, Name: "Merovius"},
+		{ID: 2, Name: "Koebi"},
+	}, []Card{
+		{ID: []byte("aaaa"), User: 1},
+		{ID: []byte("baaa"), User: 2},
+	}, []Transaction{
+		{ID: 1, User: 1, Time: old, Amount: 5000, Kind: "Aufladung"},
+		{ID: 2, User: 1, Card: []byte("aaaa"), Time: old.Add(time.Hour), Amount: -100, Kind: "Kartenswipe", Product: mate},
+		{ID: 3, User: 1, Card: []byte("aaaa"), Time: last, Amount: -100, Kind: "Kartenswipe", Product: mate},
+		{ID: 4, User: 1, Card: []byte("aaaa"), Time: last.Add(time.Hour), Amount: -150, Kind: "Kartenswipe", Product: beer},
+		{ID: 5, User: 1, Card: []byte("aaaa"), Time: this, Amount: -150, Kind: "Kartenswipe", Product: beer},
+		{ID: 6, User: 1, Card: []byte("aaaa"), Time: this.Add(time.Minute), Amount: 150, Kind: "Storno", Product: beer, Reverses: sql.NullInt64{Int64: 5, Valid: true}},
+		{ID: 7, User: 1, Card: []byte("aaaa"), Time: this.Add(2 * time.Hour), Amount: -100, Kind: "Kartenswipe", Product: mate},
+		{ID: 8, User: 2, Time: last, Amount: 1000, Kind: "Aufladung"},
+		{ID: 9, User: 2, Card: []byte("baaa"), Time: this.Add(time.Hour), Amount: -150, Kind: "Kartenswipe", Product: beer},
+		{ID: 10, User: 2, Card: []byte("baaa"), Time: this.Add(time.Hour + time.Minute), Amount: -150, Kind: "Kartenswipe", Product: beer},
+		{ID: 11, User: 2, Time: this, Amount: -50, Kind: "Überweisung"},
+	})
+
+	spending, err := k.GetMonthlySpending(User{ID: 1}, 12)
+	if err != nil {
+		t.Fatalf("GetMonthlySpending() == (_, %v), want (_, nil)", err)
+	}
+	if len(spending) != 12 {
+		t.Fatalf("GetMonthlySpending() returned %d months, want 12", len(spending))
+	}
+	for i, s := range spending {
+		var want int64
+		switch i {
+		case 10:
+			want = 250
+		case 11:
+			want = 100
+		}
+		if s.Amount != want {
+			t.Errorf("spending in month %d (%v) == %d, want %d", i, s.Month, s.Amount, want)
+		}
+	}
+	if m := spending[11].Month; m.Year() != now.Year() || m.Month() != now.Month() || m.Day() != 1 {
+		t.Errorf("last month of GetMonthlySpending() is %v, want the current one", m)
+	}
+	since := spending[0].Month
+
+	tcs := []struct {
+		user  int
+		limit int
+		want  []ProductSales
+	}{
+		{0, 5, []ProductSales{{"Bier", 3, 450}, {"Mate", 2, 200}}},
+		{0, 1, []ProductSales{{"Bier", 3, 450}}},
+		{1, 5, []ProductSales{{"Mate", 2, 200}, {"Bier", 1, 150}}},
+		{2, 5, []ProductSales{{"Bier", 2, 300}}},
+	}
+	for _, tc := range tcs {
+		got, err := k.GetTopProducts(tc.user, since, tc.limit)
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("GetTopProducts(%d, _, %d) == (%v, %v), want (%v, nil)", tc.user, tc.limit, got, err, tc.want)
+		}
+	}
+
+	times, err := k.GetSwipeTimes(since)
+	if err != nil {
+		t.Fatalf("GetSwipeTimes() == (_, %v), want (_, nil)", err)
+	}
+	var want SwipeTimes
+	want.Hour[20], want.Hour[21], want.Hour[22] = 1, 3, 1
+	want.Weekday[last.Weekday()] += 2
+	want.Weekday[this.Weekday()] += 3
+	if *times != want {
+		t.Errorf("GetSwipeTimes() == %+v, want %+v", *times, want)
+	}
+}
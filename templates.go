@@ -9,11 +9,14 @@ import (
 )
 
 // TemplateInput is the input to a rendered Template. Body should name a
-// template-file. Data will be provided to the Body-Template.
+// template-file. Data will be provided to the Body-Template. Forms in
+// templates must contain {{ csrfField }}.
 type TemplateInput struct {
 	Title string
 	Body  string
 	Data  interface{}
+	// CSRFToken is inserted into forms by the csrfField template function.
+	CSRFToken string
 }
 
 var (
@@ -48,6 +51,13 @@ func init() {
 			"toEuros": func(x int) float64 {
 				return float64(x) / 100
 			},
+			"toEuros64": func(x int64) float64 {
+				return float64(x) / 100
+			},
+			// Replaced in ExecuteTemplate.
+			"csrfField": func() template.HTML {
+				return ""
+			},
 		})
 
 		t = template.Must(t.Parse(string(layout)))
@@ -59,5 +69,14 @@ func init() {
 
 // ExecuteTemplate executes a template to w.
 func ExecuteTemplate(w io.Writer, data TemplateInput) error {
-	return parsedTemplates[data.Body].Execute(w, data)
+	t, err := parsedTemplates[data.Body].Clone()
+	if err != nil {
+		return err
+	}
+	t.Funcs(template.FuncMap{
+		"csrfField": func() template.HTML {
+			return csrfField(data.CSRFToken)
+		},
+	})
+	return t.Execute(w, data)
 }
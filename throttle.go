@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// LoginFreeAttempts is the number of failed logins per username or IP
+	// address, that are allowed without any delay.
+	LoginFreeAttempts = 3
+	// LoginBackoff is how long logins are locked after the first failed
+	// login exceeding LoginFreeAttempts. It doubles with every further failed
+	// login.
+	LoginBackoff = time.Second
+	// LoginMaxBackoff is the longest time logins are locked.
+	LoginMaxBackoff = 15 * time.Minute
+	// LoginForget is how long failed logins are remembered.
+	LoginForget = 24 * time.Hour
+)
+
+// ErrTooManyAttempts means that a login was refused without checking the
+// password, because there where too many failed logins for the username or
+// IP address.
+var ErrTooManyAttempts = errors.New("too many failed logins, try again later")
+
+// Lockout describes the failed logins for a username or IP address.
+type Lockout struct {
+	// Key is "user:" followed by the username or "ip:" followed by the IP
+	// address.
+	Key string
+	// Failures is the number of failed logins since the last successful one.
+	Failures int
+	// Last is the time of the last failed login.
+	Last time.Time
+	// Until is the time until which logins are refused.
+	Until time.Time
+}
+
+// loginThrottle tracks failed logins. The zero value is ready to use.
+type loginThrottle struct {
+	mu    sync.Mutex
+	now   func() time.Time
+	fails map[string]*Lockout
+}
+
+func (l *loginThrottle) time() time.Time {
+	if l.now == nil {
+		return time.Now()
+	}
+	return l.now()
+}
+
+// expire removes all entries older than LoginForget. l.mu must be held.
+func (l *loginThrottle) expire(now time.Time) {
+	for k, f := range l.fails {
+		if now.Sub(f.Last) >= LoginForget {
+			delete(l.fails, k)
+		}
+	}
+}
+
+// backoff returns how long logins are locked after the given number of failed
+// logins.
+func backoff(failures int) time.Duration {
+	n := failures - LoginFreeAttempts
+	if n <= 0 {
+		return 0
+	}
+	if n > 30 || LoginBackoff<<uint(n-1) >= LoginMaxBackoff {
+		return LoginMaxBackoff
+	}
+	return LoginBackoff << uint(n-1)
+}
+
+// attempt reserves a login attempt for all keys, by recording it as failed
+// before the password is checked. Checking a password takes a while, so
+// otherwise all attempts of a concurrent burst would pass before the first
+// failure is recorded. It returns false without recording anything, if logins
+// are refused for any of keys.
+func (l *loginThrottle) attempt(keys ...string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.time()
+	for _, k := range keys {
+		if f, ok := l.fails[k]; ok && now.Before(f.Until) {
+			return false
+		}
+	}
+
+	l.expire(now)
+	if l.fails == nil {
+		l.fails = make(map[string]*Lockout)
+	}
+	for _, k := range keys {
+		f, ok := l.fails[k]
+		if !ok {
+			f = &Lockout{Key: k}
+			l.fails[k] = f
+		}
+		f.Failures++
+		f.Last = now
+		if d := backoff(f.Failures); d > 0 {
+			f.Until = now.Add(d)
+		}
+	}
+	return true
+}
+
+// release takes back an attempt reserved for keys, that turned out to be
+// successful.
+func (l *loginThrottle) release(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, k := range keys {
+		f, ok := l.fails[k]
+		if !ok {
+			continue
+		}
+		if f.Failures--; f.Failures <= 0 {
+			delete(l.fails, k)
+			continue
+		}
+		f.Until = time.Time{}
+		if d := backoff(f.Failures); d > 0 {
+			f.Until = f.Last.Add(d)
+		}
+	}
+}
+
+// clear forgets all failed logins for keys.
+func (l *loginThrottle) clear(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, k := range keys {
+		delete(l.fails, k)
+	}
+}
+
+// list returns all remembered failed logins, the most recent first.
+func (l *loginThrottle) list() []Lockout {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.expire(l.time())
+	var out []Lockout
+	for _, f := range l.fails {
+		out = append(out, *f)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Last.After(out[j].Last) })
+	return out
+}
+
+// Login authenticates username with password, like Authenticate, but tracks
+// failed logins per username and per IP address ip. After LoginFreeAttempts
+// failed logins for either, logins are refused with ErrTooManyAttempts for an
+// exponentially growing time. A successful login clears the failed logins of
+// the username, but not of the IP address, so that one valid account can't be
+// used to keep guessing others.
+func (k *Kasse) Login(username string, password []byte, ip string) (*User, error) {
+	userKey, ipKey := "user:"+username, "ip:"+ip
+	if !k.throttle.attempt(userKey, ipKey) {
+		k.log.Printf("Refusing login of %v from %s", username, ip)
+		return nil, ErrTooManyAttempts
+	}
+
+	user, err := k.Authenticate(username, password)
+	if err == nil {
+		k.throttle.clear(userKey)
+		k.throttle.release(ipKey)
+	} else if err != ErrWrongAuth {
+		k.throttle.release(userKey, ipKey)
+	}
+	return user, err
+}
+
+// GetLockouts returns the failed logins for all usernames and IP addresses.
+// It returns ErrNotTreasurer if treasurer may not see them.
+func (k *Kasse) GetLockouts(treasurer User) ([]Lockout, error) {
+	if ok, err := k.IsTreasurer(treasurer); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, ErrNotTreasurer
+	}
+	return k.throttle.list(), nil
+}
+
+// ClearLockout forgets the failed logins for key, as returned in a Lockout.
+// It returns ErrNotTreasurer if treasurer may not clear them.
+func (k *Kasse) ClearLockout(treasurer User, key string) error {
+	k.log.Printf("Treasurer %s clears failed logins of %s", treasurer.Name, key)
+
+	if ok, err := k.IsTreasurer(treasurer); err != nil {
+		return err
+	} else if !ok {
+		return ErrNotTreasurer
+	}
+	if err := k.audit(k.db, treasurer, "lockout.clear", key, nil, nil); err != nil {
+		return err
+	}
+	k.throttle.clear(key)
+	return nil
+}
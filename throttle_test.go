@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginThrottle(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	defer k.db.Close()
+
+	now := time.Now()
+	k.throttle.now = func() time.Time { return now }
+
+	mero, err := k.RegisterUser("Merovius", []byte("foobar"))
+	if err != nil {
+		t.Fatalf("RegisterUser(Merovius) == (_, %v), want (_, nil)", err)
+	}
+	if _, err := k.RegisterUser("Koebi", []byte("password")); err != nil {
+		t.Fatalf("RegisterUser(Koebi) == (_, %v), want (_, nil)", err)
+	}
+	if _, err := k.db.Exec(`UPDATE users SET treasurer = TRUE WHERE user_id = $1`, mero.ID); err != nil {
+		t.Fatalf("could not make %v a treasurer: %v", mero.Name, err)
+	}
+
+	// Every entry is one login attempt, advancing the clock by wait before it.
+	tests := []struct {
+		wait     time.Duration
+		user     string
+		password string
+		ip       string
+		want     error
+	}{
+		{0, "Koebi", "wrong", "10.0.0.1", ErrWrongAuth},
+		{0, "Koebi", "wrong", "10.0.0.2", ErrWrongAuth},
+		{0, "Koebi", "wrong", "10.0.0.3", ErrWrongAuth},
+		{0, "Koebi", "wrong", "10.0.0.4", ErrWrongAuth},
+		// Koebi is now locked for LoginBackoff, even with the right password.
+		{0, "Koebi", "password", "10.0.0.5", ErrTooManyAttempts},
+		// Other users can still log in from other addresses.
+		{0, "Merovius", "foobar", "10.0.0.6", nil},
+		{LoginBackoff, "Koebi", "wrong", "10.0.0.5", ErrWrongAuth},
+		// The lockout doubled.
+		{LoginBackoff, "Koebi", "password", "10.0.0.5", ErrTooManyAttempts},
+		{LoginBackoff, "Koebi", "password", "10.0.0.5", nil},
+		// A successful login cleared the lockout.
+		{0, "Koebi", "wrong", "10.0.0.5", ErrWrongAuth},
+		{0, "Koebi", "password", "10.0.0.5", nil},
+		// Guessing different usernames from one address is limited as well.
+		{0, "a", "wrong", "10.0.0.7", ErrWrongAuth},
+		{0, "b", "wrong", "10.0.0.7", ErrWrongAuth},
+		{0, "c", "wrong", "10.0.0.7", ErrWrongAuth},
+		{0, "d", "wrong", "10.0.0.7", ErrWrongAuth},
+		{0, "Merovius", "foobar", "10.0.0.7", ErrTooManyAttempts},
+		// A successful login doesn't clear the failed logins of the address.
+		{0, "e", "wrong", "10.0.0.8", ErrWrongAuth},
+		{0, "f", "wrong", "10.0.0.8", ErrWrongAuth},
+		{0, "g", "wrong", "10.0.0.8", ErrWrongAuth},
+		{0, "Merovius", "foobar", "10.0.0.8", nil},
+		{0, "h", "wrong", "10.0.0.8", ErrWrongAuth},
+		{0, "Merovius", "foobar", "10.0.0.8", ErrTooManyAttempts},
+	}
+
+	for _, tc := range tests {
+		now = now.Add(tc.wait)
+		if _, err := k.Login(tc.user, []byte(tc.password), tc.ip); err != tc.want {
+			t.Errorf("Login(%s, %s, %s) == (_, %v), want (_, %v)", tc.user, tc.password, tc.ip, err, tc.want)
+		}
+	}
+
+	koebi := User{ID: 2, Name: "Koebi"}
+	if _, err := k.GetLockouts(koebi); err != ErrNotTreasurer {
+		t.Errorf("GetLockouts by non-treasurer == (_, %v), want (_, %v)", err, ErrNotTreasurer)
+	}
+	lockouts, err := k.GetLockouts(*mero)
+	if err != nil {
+		t.Fatalf("GetLockouts() == (_, %v), want (_, nil)", err)
+	}
+	var found bool
+	for _, l := range lockouts {
+		if l.Key == "ip:10.0.0.7" {
+			found = true
+			if l.Failures != 4 || !l.Until.After(now) {
+				t.Errorf("Lockout of 10.0.0.7 == %+v, want 4 failures and locked", l)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("GetLockouts() == %v, want lockout of 10.0.0.7", lockouts)
+	}
+
+	if err := k.ClearLockout(*mero, "ip:10.0.0.7"); err != nil {
+		t.Errorf("ClearLockout(10.0.0.7) == %v, want nil", err)
+	}
+	if _, err := k.Login("Merovius", []byte("foobar"), "10.0.0.7"); err != nil {
+		t.Errorf("Login after ClearLockout == (_, %v), want (_, nil)", err)
+	}
+
+	now = now.Add(LoginForget)
+	if lockouts, err := k.GetLockouts(*mero); err != nil || len(lockouts) != 0 {
+		t.Errorf("GetLockouts() after LoginForget == (%v, %v), want ([], nil)", lockouts, err)
+	}
+}
+
+func TestLoginThrottleConcurrent(t *testing.T) {
+	t.Parallel()
+
+	var l loginThrottle
+	// Attempts are recorded before the password is checked, so of a burst of
+	// concurrent attempts only as many pass, as would have been allowed one
+	// after the other.
+	var passed int
+	for i := 0; i < 10; i++ {
+		if l.attempt("user:Koebi", "ip:10.0.0.1") {
+			passed++
+		}
+	}
+	if want := LoginFreeAttempts + 1; passed != want {
+		t.Errorf("%d of 10 concurrent attempts passed, want %d", passed, want)
+	}
+
+	l.release("ip:10.0.0.1")
+	if f := l.fails["ip:10.0.0.1"]; f.Failures != LoginFreeAttempts || !f.Until.IsZero() {
+		t.Errorf("Lockout after release == %+v, want %d failures and not locked", f, LoginFreeAttempts)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// TopUp represents a request to top up an account (as in the database
+// schema). It only becomes a transaction after a treasurer approved it.
+type TopUp struct {
+	ID        int       `db:"topup_id"`
+	User      int       `db:"user_id"`
+	Amount    int       `db:"amount"`
+	Requested time.Time `db:"requested"`
+	State     string    `db:"state"`
+}
+
+// PendingTopUp is a TopUp that still waits for approval, together with the
+// name of the user who requested it.
+type PendingTopUp struct {
+	TopUp
+	Name string `db:"name"`
+}
+
+// States a TopUp can be in.
+const (
+	TopUpPending  = "pending"
+	TopUpApproved = "approved"
+	TopUpRejected = "rejected"
+)
+
+// ErrInvalidAmount means that an amount of zero or less was given where a
+// positive amount is required.
+var ErrInvalidAmount = errors.New("amount must be positive")
+
+// ErrTopUpNotFound means that there is no pending top-up request with the
+// given id.
+var ErrTopUpNotFound = errors.New("top-up request not found")
+
+// ErrOwnTopUp means that a treasurer tried to approve their own top-up
+// request. It has to be approved by another treasurer.
+var ErrOwnTopUp = errors.New("treasurers can't approve their own top-ups")
+
+// ErrNotTreasurer means that an action requiring a treasurer was attempted by
+// a user who isn't one.
+var ErrNotTreasurer = errors.New("user is not a treasurer")
+
+// RequestTopUp creates a pending request to top up the account of user by
+// amount cents. The account is not credited until a treasurer calls
+// ApproveTopUp. It returns ErrInvalidAmount if amount is not positive.
+func (k *Kasse) RequestTopUp(user User, amount int) (*TopUp, error) {
+	k.log.Printf("User %s requests top-up of %d", user.Name, amount)
+
+	if amount <= 0 {
+		return nil, ErrInvalidAmount
+	}
+
+	t := &TopUp{
+		User:      user.ID,
+		Amount:    amount,
+		Requested: time.Now(),
+		State:     TopUpPending,
+	}
+
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := tx.Get(&t.ID, `INSERT INTO topups (user_id, amount, requested, state) VALUES ($1, $2, $3, $4) RETURNING topup_id`, t.User, t.Amount, t.Requested, t.State); err != nil {
+		return nil, err
+	}
+	if err := k.audit(tx, user, "topup.request", fmt.Sprintf("topup %d", t.ID), nil, t); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// IsTreasurer returns whether user may approve top-up requests.
+func (k *Kasse) IsTreasurer(user User) (bool, error) {
+	var treasurer bool
+	if err := k.db.Get(&treasurer, `SELECT treasurer FROM users WHERE user_id = $1`, user.ID); err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return treasurer, nil
+}
+
+// CountTreasurers returns the number of treasurers. As nobody can approve
+// their own top-ups, there have to be at least two for every top-up to be
+// approvable.
+func (k *Kasse) CountTreasurers() (int, error) {
+	var n int
+	if err := k.db.Get(&n, `SELECT COUNT(*) FROM users WHERE treasurer = $1`, true); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// GetPendingTopUps gets all top-up requests waiting for approval, oldest
+// first.
+func (k *Kasse) GetPendingTopUps() ([]PendingTopUp, error) {
+	var topups []PendingTopUp
+	if err := k.db.Select(&topups, `SELECT topup_id, topups.user_id, amount, requested, state, name FROM topups LEFT JOIN users ON topups.user_id = users.user_id WHERE state = $1 ORDER BY requested`, TopUpPending); err != nil {
+		return nil, err
+	}
+	return topups, nil
+}
+
+// GetPendingTopUpsFor gets all top-up requests of user waiting for approval.
+func (k *Kasse) GetPendingTopUpsFor(user User) ([]TopUp, error) {
+	var topups []TopUp
+	if err := k.db.Select(&topups, `SELECT topup_id, user_id, amount, requested, state FROM topups WHERE user_id = $1 AND state = $2 ORDER BY requested`, user.ID, TopUpPending); err != nil {
+		return nil, err
+	}
+	return topups, nil
+}
+
+// ApproveTopUp approves the pending top-up request id and credits the
+// requested amount to the account as a transaction of kind "Aufladung". It
+// returns ErrNotTreasurer if treasurer may not approve requests,
+// ErrTopUpNotFound if there is no pending request with that id and ErrOwnTopUp
+// if the request is the treasurer's own.
+func (k *Kasse) ApproveTopUp(id int, treasurer User) error {
+	return k.decideTopUp(id, treasurer, TopUpApproved)
+}
+
+// RejectTopUp rejects the pending top-up request id, without crediting
+// anything. It returns the same errors as ApproveTopUp, except that treasurers
+// may reject their own requests.
+func (k *Kasse) RejectTopUp(id int, treasurer User) error {
+	return k.decideTopUp(id, treasurer, TopUpRejected)
+}
+
+func (k *Kasse) decideTopUp(id int, treasurer User, state string) error {
+	k.log.Printf("Treasurer %s sets top-up %d to %s", treasurer.Name, id, state)
+
+	if ok, err := k.IsTreasurer(treasurer); err != nil {
+		return err
+	} else if !ok {
+		return ErrNotTreasurer
+	}
+
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var t TopUp
+	if err := tx.Get(&t, `SELECT topup_id, user_id, amount, requested, state FROM topups WHERE topup_id = $1 AND state = $2`, id, TopUpPending); err == sql.ErrNoRows {
+		return ErrTopUpNotFound
+	} else if err != nil {
+		return err
+	}
+	if state == TopUpApproved && t.User == treasurer.ID {
+		return ErrOwnTopUp
+	}
+
+	now := time.Now()
+	var tid sql.NullInt64
+	if state == TopUpApproved {
+		if err := tx.Get(&tid.Int64, `INSERT INTO transactions (user_id, card_id, time, amount, kind) VALUES ($1, NULL, $2, $3, $4) RETURNING transaction_id`, t.User, now, t.Amount, "Aufladung"); err != nil {
+			return err
+		}
+		if err := adjustBalance(tx, t.User, t.Amount); err != nil {
+			return err
+		}
+		tid.Valid = true
+	}
+
+	// The request might have been decided concurrently since we read it, in
+	// which case it must not be credited twice.
+	r, err := tx.Exec(`UPDATE topups SET state = $1, decided_by = $2, decided = $3, transaction_id = $4 WHERE topup_id = $5 AND state = $6`, state, treasurer.ID, now, tid, id, TopUpPending)
+	if err != nil {
+		return err
+	}
+	if n, err := r.RowsAffected(); err != nil {
+		return err
+	} else if n != 1 {
+		return ErrTopUpNotFound
+	}
+	after := t
+	after.State = state
+	if err := k.audit(tx, treasurer, "topup."+state, fmt.Sprintf("topup %d", id), t, after); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,93 @@
+package main
+
+import "testing"
+
+func TestTopUp(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	defer k.db.Close()
+
+	mero := User{ID: 1, Name: "Merovius", Password: []byte("password")}
+	koebi := User{ID: 2, Name: "Koebi", Password: []byte("password1")}
+	insertData(t, k.db, []User{mero, koebi}, nil, nil)
+	if _, err := k.db.Exec(`UPDATE users SET treasurer = TRUE WHERE user_id = $1`, mero.ID); err != nil {
+		t.Fatalf("could not make %v a treasurer: %v", mero.Name, err)
+	}
+	if n, err := k.CountTreasurers(); err != nil || n != 1 {
+		t.Errorf("CountTreasurers() == (%v, %v), want (1, nil)", n, err)
+	}
+
+	if _, err := k.RequestTopUp(koebi, 0); err != ErrInvalidAmount {
+		t.Errorf("RequestTopUp(%v, 0) == (_, %v), want (_, %v)", koebi.Name, err, ErrInvalidAmount)
+	}
+
+	t1, err := k.RequestTopUp(koebi, 1000)
+	if err != nil {
+		t.Fatalf("RequestTopUp(%v, 1000) == (_, %v), want (_, nil)", koebi.Name, err)
+	}
+	t2, err := k.RequestTopUp(koebi, 500)
+	if err != nil {
+		t.Fatalf("RequestTopUp(%v, 500) == (_, %v), want (_, nil)", koebi.Name, err)
+	}
+
+	pending, err := k.GetPendingTopUps()
+	if err != nil || len(pending) != 2 {
+		t.Fatalf("GetPendingTopUps() == (%v, %v), want 2 top-ups", pending, err)
+	}
+	if pending[0].Name != koebi.Name {
+		t.Errorf("GetPendingTopUps()[0].Name == %q, want %q", pending[0].Name, koebi.Name)
+	}
+
+	if b, err := k.GetBalance(koebi); err != nil || b != 0 {
+		t.Errorf("GetBalance(%v) == (%v, %v), want (0, nil)", koebi.Name, b, err)
+	}
+
+	tcs := []struct {
+		id        int
+		treasurer User
+		approve   bool
+		wantErr   error
+		balance   int64
+	}{
+		{t1.ID, koebi, true, ErrNotTreasurer, 0},
+		{t1.ID, mero, true, nil, 1000},
+		{t1.ID, mero, true, ErrTopUpNotFound, 1000},
+		{t2.ID, mero, false, nil, 1000},
+		{t2.ID, mero, true, ErrTopUpNotFound, 1000},
+		{23, mero, true, ErrTopUpNotFound, 1000},
+	}
+
+	for _, tc := range tcs {
+		var err error
+		if tc.approve {
+			err = k.ApproveTopUp(tc.id, tc.treasurer)
+		} else {
+			err = k.RejectTopUp(tc.id, tc.treasurer)
+		}
+		if err != tc.wantErr {
+			t.Errorf("deciding top-up %d as %v (approve: %v) == %v, want %v", tc.id, tc.treasurer.Name, tc.approve, err, tc.wantErr)
+		}
+		if b, err := k.GetBalance(koebi); err != nil || b != tc.balance {
+			t.Errorf("GetBalance(%v) == (%v, %v), want (%v, nil)", koebi.Name, b, err, tc.balance)
+		}
+	}
+
+	if pending, err := k.GetPendingTopUpsFor(koebi); err != nil || len(pending) != 0 {
+		t.Errorf("GetPendingTopUpsFor(%v) == (%v, %v), want ([], nil)", koebi.Name, pending, err)
+	}
+
+	own, err := k.RequestTopUp(mero, 1000)
+	if err != nil {
+		t.Fatalf("RequestTopUp(%v, 1000) == (_, %v), want (_, nil)", mero.Name, err)
+	}
+	if err := k.ApproveTopUp(own.ID, mero); err != ErrOwnTopUp {
+		t.Errorf("ApproveTopUp(%d, %v) of own top-up == %v, want %v", own.ID, mero.Name, err, ErrOwnTopUp)
+	}
+	if b, err := k.GetBalance(mero); err != nil || b != 0 {
+		t.Errorf("GetBalance(%v) == (%v, %v), want (0, nil)", mero.Name, b, err)
+	}
+	if err := k.RejectTopUp(own.ID, mero); err != nil {
+		t.Errorf("RejectTopUp(%d, %v) of own top-up == %v, want nil", own.ID, mero.Name, err)
+	}
+}
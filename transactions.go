@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TransactionQuery selects transactions of a user for QueryTransactions. Zero
+// values of the filter fields mean that they are not restricted.
+type TransactionQuery struct {
+	// User is the id of the user whose transactions are selected.
+	User int
+	// From and Until restrict the time of the selected transactions to the
+	// half-open interval [From, Until).
+	From  time.Time
+	Until time.Time
+	// Kind selects only transactions of the given kind.
+	Kind string
+	// Card selects only transactions made with the given card.
+	Card []byte
+	// Before is a cursor for paging. If it is not zero, only transactions
+	// older than the one with this id are selected.
+	Before int
+	// Limit is the maximum number of transactions returned. If it is ≤ 0, all
+	// matching transactions are returned.
+	Limit int
+}
+
+// QueryTransactions gets the transactions selected by q, newest first. The
+// Balance of every returned transaction is the balance of the account right
+// after it, regardless of the filters in q.
+func (k *Kasse) QueryTransactions(q TransactionQuery) ([]Transaction, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	where = append(where, "t.user_id = "+arg(q.User))
+	if !q.From.IsZero() {
+		where = append(where, "t.time >= "+arg(q.From))
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "t.time < "+arg(q.Until))
+	}
+	if q.Kind != "" {
+		where = append(where, "t.kind = "+arg(q.Kind))
+	}
+	if q.Card != nil {
+		where = append(where, "t.card_id = "+arg(q.Card))
+	}
+	if q.Before != 0 {
+		c := arg(q.Before)
+		cursor := "(SELECT time FROM transactions WHERE transaction_id = " + c + ")"
+		where = append(where, "(t.time < "+cursor+" OR (t.time = "+cursor+" AND t.transaction_id < "+c+"))")
+	}
+
+	query := `SELECT t.transaction_id, t.user_id, t.card_id, t.time, t.amount, t.kind, t.product_id, t.reader, t.reverses, t.counterpart_id, p.name AS product_name, cu.name AS counterparty
+		FROM transactions t LEFT JOIN products p ON t.product_id = p.product_id
+		LEFT JOIN transactions ct ON t.counterpart_id = ct.transaction_id LEFT JOIN users cu ON ct.user_id = cu.user_id
+		WHERE ` + strings.Join(where, " AND ") + `
+		ORDER BY t.time DESC, t.transaction_id DESC`
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var transactions []Transaction
+	if err := tx.Select(&transactions, query, args...); err != nil {
+		return nil, err
+	}
+	if len(transactions) == 0 {
+		return transactions, nil
+	}
+	if err := fillBalances(tx, q.User, transactions); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// fillBalances sets the Balance of transactions, which are transactions of
+// user, newest first. Starting from the stored balance, it walks all
+// transactions of user back to the oldest of them and subtracts their amounts,
+// so that the balance is right even if transactions in between were filtered
+// out.
+func fillBalances(tx *sqlx.Tx, user int, transactions []Transaction) error {
+	balance, err := getBalance(tx, user)
+	if err != nil {
+		return err
+	}
+
+	oldest := transactions[len(transactions)-1].ID
+	cursor := `(SELECT time FROM transactions WHERE transaction_id = $2)`
+	rows, err := tx.Query(`SELECT transaction_id, amount FROM transactions
+		WHERE user_id = $1 AND (time > `+cursor+` OR (time = `+cursor+` AND transaction_id >= $2))
+		ORDER BY time DESC, transaction_id DESC`, user, oldest)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	i := 0
+	for rows.Next() && i < len(transactions) {
+		var (
+			id     int
+			amount int64
+		)
+		if err := rows.Scan(&id, &amount); err != nil {
+			return err
+		}
+		if id == transactions[i].ID {
+			transactions[i].Balance = balance
+			i++
+		}
+		balance -= amount
+	}
+	return rows.Err()
+}
+
+// GetTransactionKinds gets all kinds of transactions, that exist for user.
+func (k *Kasse) GetTransactionKinds(user User) ([]string, error) {
+	var kinds []string
+	if err := k.db.Select(&kinds, `SELECT DISTINCT kind FROM transactions WHERE user_id = $1 ORDER BY kind`, user.ID); err != nil {
+		return nil, err
+	}
+	return kinds, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueryTransactions(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t)}
+	defer k.db.Close()
+
+	mero := User{ID: 1, Name: "Merovius", Password: []byte("password")}
+	koebi := User{ID: 2, Name: "Koebi", Password: []byte("password1")}
+
+	day := func(d, h int) time.Time {
+		return time.Date(2015, 4, d, h, 0, 0, 0, time.UTC)
+	}
+
+	insertData(t, k.db, []User{mero, koebi}, []Card{
+		{ID: []byte("aaaa"), User: 1},
+		{ID: []byte("aaab"), User: 1},
+	}, []Transaction{
+		{ID: 1, User: 1, Time: day(1, 12), Amount: 1000, Kind: "Aufladung"},
+		{ID: 2, User: 1, Card: []byte("aaaa"), Time: day(2, 12), Amount: -100, Kind: "Kartenswipe"},
+		{ID: 3, User: 2, Time: day(2, 13), Amount: 500, Kind: "Aufladung"},
+		{ID: 4, User: 1, Card: []byte("aaab"), Time: day(3, 12), Amount: -150, Kind: "Kartenswipe"},
+		// Same time as 4, so the id decides the order.
+		{ID: 5, User: 1, Card: []byte("aaaa"), Time: day(3, 12), Amount: -100, Kind: "Kartenswipe"},
+		{ID: 6, User: 1, Time: day(4, 12), Amount: 500, Kind: "Aufladung"},
+	})
+
+	tcs := []struct {
+		q        TransactionQuery
+		ids      []int
+		balances []int64
+	}{
+		{TransactionQuery{User: 1}, []int{6, 5, 4, 2, 1}, []int64{1150, 650, 750, 900, 1000}},
+		{TransactionQuery{User: 2}, []int{3}, []int64{500}},
+		{TransactionQuery{User: 1, Limit: 2}, []int{6, 5}, []int64{1150, 650}},
+		{TransactionQuery{User: 1, Before: 5, Limit: 2}, []int{4, 2}, []int64{750, 900}},
+		{TransactionQuery{User: 1, Before: 2, Limit: 2}, []int{1}, []int64{1000}},
+		{TransactionQuery{User: 1, Kind: "Aufladung"}, []int{6, 1}, []int64{1150, 1000}},
+		{TransactionQuery{User: 1, Card: []byte("aaaa")}, []int{5, 2}, []int64{650, 900}},
+		{TransactionQuery{User: 1, From: day(2, 0), Until: day(4, 0)}, []int{5, 4, 2}, []int64{650, 750, 900}},
+		{TransactionQuery{User: 1, From: day(3, 0), Kind: "Kartenswipe", Before: 5}, []int{4}, []int64{750}},
+	}
+
+	for _, tc := range tcs {
+		got, err := k.QueryTransactions(tc.q)
+		if err != nil {
+			t.Errorf("QueryTransactions(%+v) == (_, %v), want (_, nil)", tc.q, err)
+			continue
+		}
+		if len(got) != len(tc.ids) {
+			t.Errorf("QueryTransactions(%+v) == %v, want ids %v", tc.q, got, tc.ids)
+			continue
+		}
+		for i := range got {
+			if got[i].ID != tc.ids[i] || got[i].Balance != tc.balances[i] {
+				t.Errorf("QueryTransactions(%+v)[%d] == (id %d, balance %d), want (id %d, balance %d)", tc.q, i, got[i].ID, got[i].Balance, tc.ids[i], tc.balances[i])
+			}
+		}
+	}
+
+	kinds, err := k.GetTransactionKinds(mero)
+	if err != nil || len(kinds) != 2 || kinds[0] != "Aufladung" || kinds[1] != "Kartenswipe" {
+		t.Errorf("GetTransactionKinds(%v) == (%v, %v), want [Aufladung Kartenswipe]", mero.Name, kinds, err)
+	}
+}
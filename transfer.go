@@ -0,0 +1,90 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrTransferToSelf means that a user tried to transfer money to themselves.
+var ErrTransferToSelf = errors.New("can't transfer money to yourself")
+
+// Transfer moves amount cents from the account of from to the account of the
+// user named to. It creates two linked transactions of kind "Überweisung",
+// one for each account. The same balance rules as for HandleCard apply to
+// from: it returns AccountEmpty and ErrAccountEmpty if the transfer would
+// exceed the overdraft limit, otherwise the ResultCode describes the balance
+// of from after the transfer. It returns ErrInvalidAmount if amount is not
+// positive and ErrUserNotFound if there is no user named to.
+func (k *Kasse) Transfer(from User, to string, amount int) (ResultCode, error) {
+	k.log.Printf("User %s transfers %d to %s", from.Name, amount, to)
+
+	if amount <= 0 {
+		return 0, ErrInvalidAmount
+	}
+
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var recipient User
+	if err := tx.Get(&recipient, `SELECT user_id, name FROM users WHERE name = $1`, to); err == sql.ErrNoRows {
+		return 0, ErrUserNotFound
+	} else if err != nil {
+		return 0, err
+	}
+	if recipient.ID == from.ID {
+		return 0, ErrTransferToSelf
+	}
+
+	// Re-read the sender, so a changed overdraft limit is applied.
+	if err := tx.Get(&from, `SELECT user_id, name, overdraft FROM users WHERE user_id = $1`, from.ID); err == sql.ErrNoRows {
+		return 0, ErrUserNotFound
+	} else if err != nil {
+		return 0, err
+	}
+
+	balance, err := getBalance(tx, from.ID)
+	if err != nil {
+		return 0, err
+	}
+	code := k.resultCode(from, balance, amount)
+	if code == AccountEmpty {
+		return code, ErrAccountEmpty
+	}
+
+	now := time.Now()
+	var debit, credit int
+	if err := tx.Get(&debit, `INSERT INTO transactions (user_id, time, amount, kind) VALUES ($1, $2, $3, $4) RETURNING transaction_id`, from.ID, now, -amount, "Überweisung"); err != nil {
+		return 0, err
+	}
+	if err := tx.Get(&credit, `INSERT INTO transactions (user_id, time, amount, kind, counterpart_id) VALUES ($1, $2, $3, $4, $5) RETURNING transaction_id`, recipient.ID, now, amount, "Überweisung", debit); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(`UPDATE transactions SET counterpart_id = $1 WHERE transaction_id = $2`, credit, debit); err != nil {
+		return 0, err
+	}
+	// The balance might have changed since we read it, so the transfer is
+	// checked again and the result based on the balance after it.
+	after, err := k.withdraw(tx, from, amount)
+	if err == ErrAccountEmpty {
+		return AccountEmpty, ErrAccountEmpty
+	} else if err != nil {
+		return 0, err
+	}
+	code = k.resultCode(from, after+int64(amount), amount)
+	if err := adjustBalance(tx, recipient.ID, amount); err != nil {
+		return 0, err
+	}
+	if err := k.audit(tx, from, "transfer", fmt.Sprintf("transaction %d", debit), nil, map[string]interface{}{"to": recipient.Name, "amount": amount}); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return code, nil
+}
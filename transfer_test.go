@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestTransfer(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t), limits: &Limits{LowBalance: 500, Overdraft: 0}}
+	defer k.db.Close()
+
+	mero := User{ID: 1, Name: "Merovius"}
+	koebi := User{ID: 2, Name: "Koebi"}
+	insertData(t, k.db, []User{mero, koebi}, nil, []Transaction{
+		{ID: 1, User: 1, Time: time.Now(), Amount: 1000, Kind: "Aufladung"},
+	})
+	if _, err := k.db.Exec(`UPDATE users SET overdraft = 100 WHERE user_id = $1`, mero.ID); err != nil {
+		t.Fatalf("could not set overdraft: %v", err)
+	}
+
+	tcs := []struct {
+		from    User
+		to      string
+		amount  int
+		want    ResultCode
+		wantErr error
+		balance int64
+	}{
+		{mero, "Koebi", 0, 0, ErrInvalidAmount, 1000},
+		{mero, "Nobody", 100, 0, ErrUserNotFound, 1000},
+		{mero, "Merovius", 100, 0, ErrTransferToSelf, 1000},
+		{mero, "Koebi", 200, PaymentMade, nil, 800},
+		{mero, "Koebi", 450, LowBalance, nil, 350},
+		{mero, "Koebi", 400, Overdrawn, nil, -50},
+		{mero, "Koebi", 100, AccountEmpty, ErrAccountEmpty, -50},
+		{koebi, "Merovius", 1100, AccountEmpty, ErrAccountEmpty, -50},
+	}
+
+	for _, tc := range tcs {
+		got, err := k.Transfer(tc.from, tc.to, tc.amount)
+		if err != tc.wantErr || (tc.want != 0 && got != tc.want) {
+			t.Errorf("Transfer(%s, %s, %d) == (%v, %v), want (%v, %v)", tc.from.Name, tc.to, tc.amount, got, err, tc.want, tc.wantErr)
+		}
+		if b, err := k.GetBalance(mero); err != nil || b != tc.balance {
+			t.Errorf("GetBalance(Merovius) after Transfer(%s, %s, %d) == (%v, %v), want (%v, nil)", tc.from.Name, tc.to, tc.amount, b, err, tc.balance)
+		}
+	}
+
+	ts, err := k.QueryTransactions(TransactionQuery{User: koebi.ID, Limit: 1})
+	if err != nil || len(ts) != 1 {
+		t.Fatalf("QueryTransactions(Koebi) == (%v, %v), want 1 transaction", ts, err)
+	}
+	if got, want := ts[0].Counterparty, (sql.NullString{String: "Merovius", Valid: true}); got != want || ts[0].Amount != 400 {
+		t.Errorf("Last transaction of Koebi == %+v, want 400 from %v", ts[0], want)
+	}
+	ms, err := k.QueryTransactions(TransactionQuery{User: mero.ID, Limit: 1})
+	if err != nil || len(ms) != 1 {
+		t.Fatalf("QueryTransactions(Merovius) == (%v, %v), want 1 transaction", ms, err)
+	}
+	if ms[0].Counterpart.Int64 != int64(ts[0].ID) || ts[0].Counterpart.Int64 != int64(ms[0].ID) {
+		t.Errorf("Transfer halves %d and %d are not linked: %v, %v", ms[0].ID, ts[0].ID, ms[0].Counterpart, ts[0].Counterpart)
+	}
+	if ms[0].Counterparty.String != "Koebi" {
+		t.Errorf("Counterparty of last transaction of Merovius == %v, want Koebi", ms[0].Counterparty)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultUndoWindow is used, if no undo window is configured for a Kasse.
+const DefaultUndoWindow = 15 * time.Second
+
+// DefaultCancelWindow is used, if no cancel window is configured for a Kasse.
+// It is much shorter than the undo window, as swiping a card again after it
+// has been removed from the reader usually means buying another product.
+const DefaultCancelWindow = 3 * time.Second
+
+// ErrNothingToUndo means that there is no swipe that could be undone, either
+// because there is none or because it is older than the undo window.
+var ErrNothingToUndo = errors.New("nothing to undo")
+
+// getUndoWindow returns how long after a swipe it can be undone.
+func (k *Kasse) getUndoWindow() time.Duration {
+	if k.undoWindow == 0 {
+		return DefaultUndoWindow
+	}
+	return k.undoWindow
+}
+
+// getCancelWindow returns how long after a swipe it can be cancelled by
+// swiping the card again.
+func (k *Kasse) getCancelWindow() time.Duration {
+	if k.cancelWindow == 0 {
+		return DefaultCancelWindow
+	}
+	return k.cancelWindow
+}
+
+// undoableSwipe returns the newest swipe matching cond, that is younger than
+// window and has not been undone yet. cond is ANDed to the query and may
+// reference the arguments $3 and following, which are given in args.
+func (k *Kasse) undoableSwipe(tx *sqlx.Tx, window time.Duration, cond string, args ...interface{}) (*Transaction, error) {
+	var t Transaction
+	args = append([]interface{}{"Kartenswipe", time.Now().Add(-window)}, args...)
+	err := tx.Get(&t, `SELECT transaction_id, user_id, card_id, time, amount, kind, product_id, reader, reverses FROM transactions t
+		WHERE kind = $1 AND time >= $2 AND `+cond+`
+		AND NOT EXISTS (SELECT 1 FROM transactions r WHERE r.reverses = t.transaction_id)
+		ORDER BY time DESC, transaction_id DESC LIMIT 1`, args...)
+	if err == sql.ErrNoRows {
+		return nil, ErrNothingToUndo
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// undo inserts a transaction of kind "Storno", that compensates t, and puts
+// the sold product back into stock. If t has been undone concurrently since
+// it was read, it returns ErrNothingToUndo.
+func (k *Kasse) undo(tx *sqlx.Tx, t *Transaction) error {
+	k.log.Printf("Undoing transaction %d", t.ID)
+	if _, err := tx.Exec(`INSERT INTO transactions (user_id, card_id, time, amount, kind, product_id, reader, reverses) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, t.User, t.Card, time.Now(), -t.Amount, "Storno", t.Product, t.Reader, t.ID); isUniqueViolation(err) {
+		return ErrNothingToUndo
+	} else if err != nil {
+		return err
+	}
+	if err := adjustBalance(tx, t.User, -t.Amount); err != nil {
+		return err
+	}
+	if !t.Product.Valid {
+		return nil
+	}
+	return adjustStock(tx, int(t.Product.Int64), 1)
+}
+
+// GetUndoableSwipe returns the last swipe of user, if it can still be undone.
+// Otherwise it returns ErrNothingToUndo.
+func (k *Kasse) GetUndoableSwipe(user User) (*Transaction, error) {
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return k.undoableSwipe(tx, k.getUndoWindow(), "user_id = $3", user.ID)
+}
+
+// UndoLastSwipe undoes the last swipe of user, by adding a compensating
+// transaction of kind "Storno" referencing it. It returns ErrNothingToUndo if
+// there is no swipe in the undo window, that has not been undone yet.
+func (k *Kasse) UndoLastSwipe(user User) error {
+	k.log.Printf("Undoing last swipe of %s", user.Name)
+
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	t, err := k.undoableSwipe(tx, k.getUndoWindow(), "user_id = $3", user.ID)
+	if err != nil {
+		return err
+	}
+	if err := k.undo(tx, t); err != nil {
+		return err
+	}
+	if err := k.audit(tx, user, "swipe.undo", fmt.Sprintf("transaction %d", t.ID), map[string]interface{}{"amount": t.Amount}, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Swipe handles a card swiped at a reader: If it cancels the previous swipe
+// (see CancelSwipe), that one is undone, otherwise the card is charged by
+// HandleCard.
+func (k *Kasse) Swipe(reader string, uid []byte) (*Result, error) {
+	res, err := k.CancelSwipe(reader, uid)
+	if err == ErrNothingToUndo {
+		res, err = k.HandleCard(reader, uid, nil)
+	}
+	return res, err
+}
+
+// CancelSwipe implements the swipe-twice-to-cancel gesture: If the card uid
+// has been charged at reader within the cancel window, that charge is undone
+// and a Result with code SwipeUndone is returned and published to the kiosk
+// displays. Otherwise it returns ErrNothingToUndo and the swipe should be
+// handled by HandleCard.
+func (k *Kasse) CancelSwipe(reader string, uid []byte) (*Result, error) {
+	tx, err := k.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	t, err := k.undoableSwipe(tx, k.getCancelWindow(), "card_id = $3 AND reader = $4", uid, reader)
+	if err != nil {
+		return nil, err
+	}
+	k.log.Printf("Card %x was swiped again at reader %q", uid, reader)
+	if err := k.undo(tx, t); err != nil {
+		return nil, err
+	}
+
+	res := &Result{
+		Code:   SwipeUndone,
+		Reader: reader,
+		UID:    uid,
+	}
+	if err := tx.Get(&res.User, `SELECT name FROM users WHERE user_id = $1`, t.User); err != nil {
+		return nil, err
+	}
+	if t.Product.Valid {
+		if err := tx.Get(&res.Product, `SELECT name FROM products WHERE product_id = $1`, t.Product.Int64); err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	balance, err := getBalance(tx, t.User)
+	if err != nil {
+		return nil, err
+	}
+	res.Account = float32(balance) / 100
+	res.Balance = balance
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	k.kiosk.Publish(res)
+	return res, nil
+}
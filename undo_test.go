@@ -0,0 +1,123 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUndo(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t), undoWindow: time.Hour}
+	defer k.db.Close()
+
+	mero := User{ID: 1, Name: "Merovius", Password: []byte("password")}
+	insertData(t, k.db, []User{mero}, []Card{{ID: []byte("aaaa"), User: 1}}, []Transaction{
+		{ID: 1, User: 1, Time: time.Now().Add(-2 * time.Hour), Amount: 1000, Kind: "Aufladung"},
+		{ID: 2, User: 1, Card: []byte("aaaa"), Time: time.Now().Add(-2 * time.Hour), Amount: -100, Kind: "Kartenswipe"},
+	})
+	mate, err := k.AddProduct(User{}, "Mate", 150)
+	if err != nil {
+		t.Fatalf("AddProduct(Mate, 150) == (_, %v), want (_, nil)", err)
+	}
+
+	if err := k.UndoLastSwipe(mero); err != ErrNothingToUndo {
+		t.Errorf("UndoLastSwipe() of swipe outside window == %v, want %v", err, ErrNothingToUndo)
+	}
+
+	if _, err := k.HandleCard("front", []byte("aaaa"), mate); err != nil {
+		t.Fatalf("HandleCard(front, aaaa, Mate) == (_, %v), want (_, nil)", err)
+	}
+	if _, err := k.CancelSwipe("back", []byte("aaaa")); err != ErrNothingToUndo {
+		t.Errorf("CancelSwipe(back, aaaa) == (_, %v), want (_, %v)", err, ErrNothingToUndo)
+	}
+	res, err := k.CancelSwipe("front", []byte("aaaa"))
+	if err != nil || res.Code != SwipeUndone || res.User != mero.Name || res.Product != mate.Name || res.Account != 9 {
+		t.Errorf("CancelSwipe(front, aaaa) == (%v, %v), want (SwipeUndone, nil) with balance 9", res, err)
+	}
+	if _, err := k.CancelSwipe("front", []byte("aaaa")); err != ErrNothingToUndo {
+		t.Errorf("CancelSwipe(front, aaaa) of undone swipe == (_, %v), want (_, %v)", err, ErrNothingToUndo)
+	}
+
+	if _, err := k.HandleCard("front", []byte("aaaa"), mate); err != nil {
+		t.Fatalf("HandleCard(front, aaaa, Mate) == (_, %v), want (_, nil)", err)
+	}
+	undo, err := k.GetUndoableSwipe(mero)
+	if err != nil || undo.Amount != -150 {
+		t.Fatalf("GetUndoableSwipe() == (%v, %v), want swipe of -150", undo, err)
+	}
+	if err := k.UndoLastSwipe(mero); err != nil {
+		t.Errorf("UndoLastSwipe() == %v, want nil", err)
+	}
+	if err := k.UndoLastSwipe(mero); err != ErrNothingToUndo {
+		t.Errorf("UndoLastSwipe() of undone swipe == %v, want %v", err, ErrNothingToUndo)
+	}
+	// An undo racing the one above read the swipe before it was undone.
+	tx, err := k.db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.undo(tx, undo); err != ErrNothingToUndo {
+		t.Errorf("undo() of undone swipe == %v, want %v", err, ErrNothingToUndo)
+	}
+	tx.Rollback()
+	if b, err := k.GetBalance(mero); err != nil || b != 900 {
+		t.Errorf("GetBalance() == (%v, %v), want (900, nil)", b, err)
+	}
+
+	ts, err := k.QueryTransactions(TransactionQuery{User: mero.ID, Kind: "Storno"})
+	if err != nil || len(ts) != 2 {
+		t.Fatalf("QueryTransactions(Storno) == (%v, %v), want 2 transactions", ts, err)
+	}
+	if !ts[0].Reverses.Valid || ts[0].Reverses.Int64 != int64(undo.ID) || ts[0].Amount != 150 {
+		t.Errorf("Last Storno == %+v, want reversal of %d with amount 150", ts[0], undo.ID)
+	}
+}
+
+func TestSwipeCancelWindow(t *testing.T) {
+	t.Parallel()
+
+	k := Kasse{db: createDB(t), log: testLogger(t), undoWindow: time.Hour, cancelWindow: time.Minute}
+	defer k.db.Close()
+
+	mero := User{ID: 1, Name: "Merovius", Password: []byte("password")}
+	insertData(t, k.db, []User{mero}, []Card{{ID: []byte("aaaa"), User: 1}}, []Transaction{
+		{ID: 1, User: 1, Time: time.Now(), Amount: 1000, Kind: "Aufladung"},
+	})
+	mate, err := k.AddProduct(User{}, "Mate", 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetDefaultProduct(User{}, mate.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Swiping twice within the cancel window undoes the first swipe.
+	if res, err := k.Swipe("front", []byte("aaaa")); err != nil || res.Code != PaymentMade {
+		t.Fatalf("first Swipe(front, aaaa) == (%v, %v), want (PaymentMade, nil)", res, err)
+	}
+	if res, err := k.Swipe("front", []byte("aaaa")); err != nil || res.Code != SwipeUndone {
+		t.Fatalf("second Swipe(front, aaaa) == (%v, %v), want (SwipeUndone, nil)", res, err)
+	}
+	if b, err := k.GetBalance(mero); err != nil || b != 1000 {
+		t.Errorf("GetBalance() after cancelled swipe == (%v, %v), want (1000, nil)", b, err)
+	}
+
+	// After the cancel window, the second swipe buys another product, even
+	// though the first one can still be undone on the dashboard.
+	if res, err := k.Swipe("front", []byte("aaaa")); err != nil || res.Code != PaymentMade {
+		t.Fatalf("Swipe(front, aaaa) == (%v, %v), want (PaymentMade, nil)", res, err)
+	}
+	if _, err := k.db.Exec(`UPDATE transactions SET time = $1 WHERE kind = 'Kartenswipe'`, time.Now().Add(-2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := k.Swipe("front", []byte("aaaa")); err != nil || res.Code != PaymentMade {
+		t.Fatalf("Swipe(front, aaaa) after cancel window == (%v, %v), want (PaymentMade, nil)", res, err)
+	}
+	if b, err := k.GetBalance(mero); err != nil || b != 700 {
+		t.Errorf("GetBalance() after two swipes == (%v, %v), want (700, nil)", b, err)
+	}
+	if _, err := k.GetUndoableSwipe(mero); err != nil {
+		t.Errorf("GetUndoableSwipe() == (_, %v), want (_, nil)", err)
+	}
+}